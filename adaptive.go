@@ -0,0 +1,469 @@
+package mcache
+
+import (
+	"container/list"
+	"context"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	adaptiveGhostCap = 1 << 8   //影子缓存的目标容量
+	adaptiveSamples  = 8        //LFU淘汰时的采样数
+	adaptiveStep     = 1.0 / 32 //每次胜出调整的比例
+)
+
+// AdaptiveStats describes the current eviction mix of an AdaptiveCache shard.
+type AdaptiveStats struct {
+	// LruShare is the fraction of evictions currently decided by recency, the
+	// rest is decided by frequency.
+	LruShare float64
+	// LruWins and LfuWins count the sampled accesses that hit one ghost cache
+	// and missed the other.
+	LruWins uint64
+	LfuWins uint64
+}
+
+// AdaptiveCache evicts either like LRU or like LFU. A sample of the keys is
+// replayed into small ghost LRU and LFU caches holding keys only, and every
+// time one of them hits while the other misses, the share of evictions decided
+// by the winner grows.
+type AdaptiveCache struct {
+	clock     Clock
+	items     map[string]*list.Element
+	evictList *list.List
+	cap       int
+	expiry    expiryIndex
+	reads     readBuffer[list.Element]
+	counters  shardCounters
+	listener  evictListener
+	weigher
+	sync.RWMutex
+
+	share    float64
+	sample   uint64
+	ghostLru LruCache
+	ghostLfu LfuCache
+	lruWins  uint64
+	lfuWins  uint64
+	accesses int
+}
+
+func (c *AdaptiveCache) Init(clock Clock, capacity int, opts ...PolicyOption) {
+	c.clock = clock
+	c.items = make(map[string]*list.Element, capacity)
+	c.evictList = list.New()
+	c.cap = capacity
+
+	o := newPolicyOptions(opts...)
+	c.expiry = newExpiryIndex(clock, capacity, false, o)
+	c.weigher.init(o)
+	c.listener.init(o)
+
+	// sample 1 key in 2^n so the ghosts hold about adaptiveGhostCap keys.
+	c.sample = 1
+	for capacity/int(c.sample) > adaptiveGhostCap {
+		c.sample <<= 1
+	}
+	ghostCap := capacity / int(c.sample)
+	c.ghostLru.Init(clock, ghostCap)
+	c.ghostLfu.Init(clock, ghostCap, WithPolicyLfuAging(LfuAging{HalveEvery: 10 * ghostCap}))
+
+	c.share = 0.5
+	c.lruWins = 0
+	c.lfuWins = 0
+	c.accesses = 0
+	c.reads.init()
+	c.counters = shardCounters{}
+}
+
+func (c *AdaptiveCache) Set(ctx context.Context, key string, val interface{}, ttl time.Duration) error {
+	c.Lock()
+	defer c.listener.unlock(c)
+	c.drainReads(ctx)
+
+	value := deref(val)
+	cost := c.cost(key, value)
+	if c.tooLarge(cost) {
+		return ValueTooLargeError
+	}
+	c.simulate(ctx, key)
+
+	it, ok := c.items[key]
+	if ok {
+		item := it.Value.(*adaptiveItem)
+		reason := evictReason(c.clock, &item.expiration, EvictReplaced)
+		c.counters.evicted(reason)
+		c.listener.add(&item.expiration, item.value, reason)
+		c.used += cost - item.cost
+		item.value = value
+		item.cost = cost
+		c.expiry.set(&item.expiration, deadline(c.clock.Now(), ttl), ttl > 0)
+		c.evictList.MoveToFront(it)
+		for c.overflow(0) {
+			if ent := c.victim(it); ent != nil {
+				c.removeElement(ent, EvictCapacity)
+			} else {
+				break
+			}
+		}
+	} else {
+		c.makeRoom(ctx, cost)
+		item := &adaptiveItem{
+			expiration: expiration{key: key},
+			value:      value,
+			cost:       cost,
+		}
+		c.expiry.set(&item.expiration, deadline(c.clock.Now(), ttl), ttl > 0)
+		c.items[key] = c.evictList.PushFront(item)
+		c.used += cost
+	}
+
+	return nil
+}
+
+// Get only takes the read lock, the hit is replayed into the recency order,
+// the frequencies and the ghost caches later. Misses are simulated by the Set
+// that usually follows them. Expired entries are left for eviction or the
+// sweeper to reclaim.
+func (c *AdaptiveCache) Get(ctx context.Context, key string) (interface{}, error) {
+	c.RLock()
+	it, ok := c.items[key]
+	if !ok {
+		c.RUnlock()
+		c.counters.miss()
+		return nil, KeyNotFoundError
+	}
+	item := it.Value.(*adaptiveItem)
+	if item.IsExpired(c.clock) {
+		c.RUnlock()
+		c.counters.miss()
+		return nil, KeyExpiredError
+	}
+	c.counters.hit()
+	val := item.value
+	full := c.reads.offer(it)
+	c.RUnlock()
+
+	if full && c.TryLock() {
+		c.drainReads(ctx)
+		c.Unlock()
+	}
+	return val, nil
+}
+
+func (c *AdaptiveCache) Exists(ctx context.Context, key string) bool {
+	c.RLock()
+	defer c.RUnlock()
+
+	it, ok := c.items[key]
+	return ok && !it.Value.(*adaptiveItem).IsExpired(c.clock)
+}
+
+// Deadline returns the deadline of the live entry of key, zero without a TTL,
+// and the load time recorded for it by SetLoadTime.
+func (c *AdaptiveCache) Deadline(ctx context.Context, key string) (time.Time, time.Duration, bool) {
+	c.RLock()
+	defer c.RUnlock()
+
+	it, ok := c.items[key]
+	if !ok || it.Value.(*adaptiveItem).IsExpired(c.clock) {
+		return time.Time{}, 0, false
+	}
+	item := it.Value.(*adaptiveItem)
+	return item.deadline(), item.loadTime, true
+}
+
+// SetLoadTime records how long the value of the entry of key took to load.
+func (c *AdaptiveCache) SetLoadTime(ctx context.Context, key string, d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+
+	if it, ok := c.items[key]; ok {
+		it.Value.(*adaptiveItem).loadTime = d
+	}
+}
+
+// Remove reports whether a live entry was removed, expired ones are removed
+// as EvictExpired whatever reason says.
+func (c *AdaptiveCache) Remove(ctx context.Context, key string, reason EvictReason) bool {
+	c.Lock()
+	defer c.listener.unlock(c)
+
+	if it, ok := c.items[key]; ok {
+		c.removeElement(it, reason)
+		return !it.Value.(*adaptiveItem).IsExpired(c.clock)
+	}
+	return false
+}
+
+func (c *AdaptiveCache) Evict(ctx context.Context, count int) {
+	c.Lock()
+	defer c.listener.unlock(c)
+	c.drainReads(ctx)
+
+	c.evict(ctx, count)
+}
+
+func (c *AdaptiveCache) Sweep(ctx context.Context) int {
+	c.Lock()
+	defer c.listener.unlock(c)
+
+	n := 0
+	now := c.clock.Now()
+	for e := c.expiry.expired(now); e != nil; e = c.expiry.expired(now) {
+		c.removeElement(c.items[e.key], EvictExpired)
+		n++
+	}
+	return n
+}
+
+func (c *AdaptiveCache) Resize(ctx context.Context, capacity int, maxBytes int64) {
+	c.Lock()
+	defer c.listener.unlock(c)
+	c.drainReads(ctx)
+
+	c.cap = capacity
+	if c.weigher.resize(maxBytes) {
+		for _, ent := range c.items {
+			item := ent.Value.(*adaptiveItem)
+			item.cost = c.cost(item.key, item.value)
+			c.used += item.cost
+		}
+	}
+
+	for c.evictList.Len() > c.cap || c.overflow(0) {
+		ent := c.victim(nil)
+		if ent == nil {
+			return
+		}
+		c.removeElement(ent, EvictCapacity)
+	}
+}
+
+func (c *AdaptiveCache) Range(ctx context.Context, fn func(Entry) bool) {
+	c.Lock()
+	defer c.Unlock()
+	c.drainReads(ctx)
+
+	for ent := c.evictList.Front(); ent != nil; ent = ent.Next() {
+		item := ent.Value.(*adaptiveItem)
+		if item.IsExpired(c.clock) {
+			continue
+		}
+		if !fn(Entry{Key: item.key, Value: item.value, ExpireAt: item.deadline(), LoadTime: item.loadTime, Freq: uint64(item.freq)}) {
+			return
+		}
+	}
+}
+
+func (c *AdaptiveCache) Insert(ctx context.Context, e Entry) error {
+	c.Lock()
+	defer c.listener.unlock(c)
+	c.drainReads(ctx)
+
+	if _, ok := c.items[e.Key]; ok {
+		return nil
+	}
+
+	now := c.clock.Now()
+	expireAt, expires := entryDeadline(now, e)
+	if expireAt.Before(now) {
+		return nil
+	}
+
+	cost := c.cost(e.Key, e.Value)
+	if c.tooLarge(cost) {
+		return ValueTooLargeError
+	}
+
+	if !c.fits(cost) {
+		return ShardFullError
+	}
+	item := &adaptiveItem{
+		expiration: expiration{key: e.Key, loadTime: e.LoadTime},
+		value:      e.Value,
+		cost:       cost,
+	}
+	if e.Freq > math.MaxUint32 {
+		item.freq = math.MaxUint32
+	} else {
+		item.freq = uint32(e.Freq)
+	}
+	c.expiry.set(&item.expiration, expireAt, expires)
+	c.items[e.Key] = c.evictList.PushBack(item)
+	c.used += cost
+	return nil
+}
+
+// Stats includes the current eviction mix of the shard.
+func (c *AdaptiveCache) Stats() ShardStats {
+	c.RLock()
+	defer c.RUnlock()
+
+	s := c.counters.stats(len(c.items))
+	s.Adaptive = &AdaptiveStats{
+		LruShare: c.share,
+		LruWins:  c.lruWins,
+		LfuWins:  c.lfuWins,
+	}
+	return s
+}
+
+func (c *AdaptiveCache) evict(ctx context.Context, count int) {
+	if c.evictList.Len() < c.cap && !c.overflow(0) {
+		return
+	}
+
+	for i := 0; i < count; i++ {
+		ent := c.victim(nil)
+		if ent == nil {
+			return
+		}
+		c.removeElement(ent, EvictCapacity)
+	}
+}
+
+// makeRoom evicts until an entry weighing cost fits.
+func (c *AdaptiveCache) makeRoom(ctx context.Context, cost int64) {
+	for c.evictList.Len() >= c.cap || c.overflow(cost) {
+		ent := c.victim(nil)
+		if ent == nil {
+			return
+		}
+		c.removeElement(ent, EvictCapacity)
+	}
+}
+
+// fits removes expired entries until an entry weighing cost fits, and reports
+// whether it does without evicting a live one.
+func (c *AdaptiveCache) fits(cost int64) bool {
+	for c.evictList.Len() >= c.cap || c.overflow(cost) {
+		e := c.expiry.expired(c.clock.Now())
+		if e == nil {
+			return false
+		}
+		c.removeElement(c.items[e.key], EvictExpired)
+	}
+	return true
+}
+
+// victim returns an expired entry if there is one. Otherwise it returns the
+// least recently used entry with probability share, and the least frequently
+// used of a few sampled entries else, skipping skip.
+func (c *AdaptiveCache) victim(skip *list.Element) *list.Element {
+	if e := c.expiry.expired(c.clock.Now()); e != nil {
+		if ent := c.items[e.key]; ent != skip {
+			return ent
+		}
+	}
+
+	if rand.Float64() < c.share {
+		for ent := c.evictList.Back(); ent != nil; ent = ent.Prev() {
+			if ent != skip {
+				return ent
+			}
+		}
+		return nil
+	}
+
+	var (
+		victim *list.Element
+		freq   uint32
+		n      int
+	)
+	for _, ent := range c.items {
+		if ent == skip {
+			continue
+		}
+		if item := ent.Value.(*adaptiveItem); victim == nil || item.freq < freq {
+			victim = ent
+			freq = item.freq
+		}
+		if n++; n >= adaptiveSamples {
+			break
+		}
+	}
+	return victim
+}
+
+// drainReads replays the buffered hits, skipping entries removed since.
+func (c *AdaptiveCache) drainReads(ctx context.Context) {
+	c.reads.drain(func(it *list.Element) {
+		item := it.Value.(*adaptiveItem)
+		if c.items[item.key] == it {
+			c.simulate(ctx, item.key)
+			c.evictList.MoveToFront(it)
+			c.hit(item)
+		}
+	})
+}
+
+// hit bumps the frequency of item, halving every frequency from time to time
+// so the LFU side can forget.
+func (c *AdaptiveCache) hit(item *adaptiveItem) {
+	item.freq++
+
+	c.accesses++
+	if c.accesses >= 10*c.cap {
+		c.accesses = 0
+		for _, ent := range c.items {
+			ent.Value.(*adaptiveItem).freq >>= 1
+		}
+	}
+}
+
+// simulate replays sampled accesses into the ghost caches and moves share
+// toward the one that hit.
+func (c *AdaptiveCache) simulate(ctx context.Context, key string) {
+	if XXHashString(key)&(c.sample-1) != 0 {
+		return
+	}
+
+	_, err := c.ghostLru.Get(ctx, key)
+	lruHit := err == nil
+	if !lruHit {
+		c.ghostLru.Set(ctx, key, struct{}{}, 0)
+	}
+
+	_, err = c.ghostLfu.Get(ctx, key)
+	lfuHit := err == nil
+	if !lfuHit {
+		c.ghostLfu.Set(ctx, key, struct{}{}, 0)
+	}
+
+	switch {
+	case lruHit && !lfuHit:
+		c.lruWins++
+		c.share += adaptiveStep
+		if c.share > 1 {
+			c.share = 1
+		}
+	case lfuHit && !lruHit:
+		c.lfuWins++
+		c.share -= adaptiveStep
+		if c.share < 0 {
+			c.share = 0
+		}
+	}
+}
+
+func (c *AdaptiveCache) removeElement(e *list.Element, reason EvictReason) {
+	c.evictList.Remove(e)
+	item := e.Value.(*adaptiveItem)
+	reason = evictReason(c.clock, &item.expiration, reason)
+	c.counters.evicted(reason)
+	c.listener.add(&item.expiration, item.value, reason)
+	delete(c.items, item.key)
+	c.expiry.remove(&item.expiration)
+	c.used -= item.cost
+}
+
+type adaptiveItem struct {
+	expiration
+	value interface{}
+	cost  int64
+	freq  uint32
+}
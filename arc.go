@@ -7,11 +7,19 @@ import (
 	"time"
 )
 
+// ArcCache implements the adaptive replacement cache. t1 and t2 hold the
+// resident keys seen once and at least twice, b1 and b2 remember the keys
+// recently evicted from them. part is the adaptive target size of t1.
 type ArcCache struct {
-	clock Clock
-	items map[string]arcItem
-	cap   int
-	sync.Mutex
+	clock    Clock
+	items    map[string]*arcItem
+	cap      int
+	expiry   expiryIndex
+	reads    readBuffer[arcItem]
+	counters shardCounters
+	listener evictListener
+	weigher
+	sync.RWMutex
 
 	part int
 	t1   arcList
@@ -20,173 +28,423 @@ type ArcCache struct {
 	b2   arcList
 }
 
-func (c *ArcCache) Init(clock Clock, capacity int) {
+func (c *ArcCache) Init(clock Clock, capacity int, opts ...PolicyOption) {
 	c.clock = clock
-	c.items = make(map[string]arcItem, capacity)
+	c.items = make(map[string]*arcItem, capacity)
 	c.cap = capacity
+	c.part = 0
+	o := newPolicyOptions(opts...)
+	c.expiry = newExpiryIndex(clock, capacity, false, o)
+	c.weigher.init(o)
+	c.listener.init(o)
 
 	l := capacity / 2
 	c.t1 = newArcCacheList(l)
 	c.t2 = newArcCacheList(c.cap - l)
 	c.b1 = newArcCacheList(c.cap - l)
 	c.b2 = newArcCacheList(l)
+	c.reads.init()
+	c.counters = shardCounters{}
 }
 
 func (c *ArcCache) Set(ctx context.Context, key string, val interface{}, ttl time.Duration) error {
 	c.Lock()
-	defer c.Unlock()
+	defer c.listener.unlock(c)
+	c.drainReads(ctx)
 
 	value := deref(val)
-	item, ok := c.items[key]
-	if ttl > 0 {
-		item.expireAt = c.clock.Now().Add(ttl)
-	} else {
-		item.expireAt = c.clock.Now().Add(defaultExpiredAt)
+	cost := c.cost(key, value)
+	if c.tooLarge(cost) {
+		return ValueTooLargeError
 	}
 
+	item, ok := c.items[key]
 	if ok {
+		reason := evictReason(c.clock, &item.expiration, EvictReplaced)
+		c.counters.evicted(reason)
+		c.listener.add(&item.expiration, item.value, reason)
+		c.used += cost - item.cost
 		item.value = value
+		item.cost = cost
+		c.update(ctx, key)
 	} else {
-		item.key = key
-		item.value = value
+		c.admit(ctx, key, cost)
+		item = &arcItem{
+			expiration: expiration{key: key},
+			value:      value,
+			cost:       cost,
+		}
 		c.items[key] = item
+		c.used += cost
 	}
+	c.expiry.set(&item.expiration, deadline(c.clock.Now(), ttl), ttl > 0)
 
-	defer func() {
-		c.evict(ctx, 1)
-		if c.t1.Has(key) || c.t2.Has(key) {
-			return
+	for c.overflow(0) && c.t1.Len()+c.t2.Len() > 1 {
+		if !c.reclaim(ctx) {
+			c.replace(ctx, key)
 		}
-
-		if ok {
-			c.update(ctx, key)
-		} else {
-			c.t1.PushFront(key)
-		}
-	}()
+	}
 	return nil
 }
 
+// Get only takes the read lock, the hit is replayed into t1 and t2 later.
+// Expired entries are left for eviction or the sweeper to reclaim.
 func (c *ArcCache) Get(ctx context.Context, key string) (interface{}, error) {
-	c.Lock()
-	defer c.Unlock()
-
+	c.RLock()
 	item, ok := c.items[key]
 	if !ok {
+		c.RUnlock()
+		c.counters.miss()
 		return nil, KeyNotFoundError
 	}
-
-	c.update(ctx, key)
 	if item.IsExpired(c.clock) {
-		c.remove(ctx, key)
+		c.RUnlock()
+		c.counters.miss()
 		return nil, KeyExpiredError
 	}
-
-	return item.value, nil
+	c.counters.hit()
+	val := item.value
+	full := c.reads.offer(item)
+	c.RUnlock()
+
+	if full && c.TryLock() {
+		c.drainReads(ctx)
+		c.Unlock()
+	}
+	return val, nil
 }
 
 func (c *ArcCache) Exists(ctx context.Context, key string) bool {
-	c.Lock()
-	defer c.Unlock()
+	c.RLock()
+	defer c.RUnlock()
 
 	item, ok := c.items[key]
-	if !ok {
-		return false
-	}
+	return ok && !item.IsExpired(c.clock)
+}
 
-	c.update(ctx, key)
-	if item.IsExpired(c.clock) {
-		c.remove(ctx, key)
-		return false
+// Deadline returns the deadline of the live entry of key, zero without a TTL,
+// and the load time recorded for it by SetLoadTime.
+func (c *ArcCache) Deadline(ctx context.Context, key string) (time.Time, time.Duration, bool) {
+	c.RLock()
+	defer c.RUnlock()
+
+	item, ok := c.items[key]
+	if !ok || item.IsExpired(c.clock) {
+		return time.Time{}, 0, false
 	}
-	return true
+	return item.deadline(), item.loadTime, true
 }
 
-func (c *ArcCache) Remove(ctx context.Context, key string) bool {
+// SetLoadTime records how long the value of the entry of key took to load.
+func (c *ArcCache) SetLoadTime(ctx context.Context, key string, d time.Duration) {
 	c.Lock()
 	defer c.Unlock()
 
-	return c.remove(ctx, key)
+	if item, ok := c.items[key]; ok {
+		item.loadTime = d
+	}
+}
+
+// Remove reports whether a live entry was removed, expired ones are removed
+// as EvictExpired whatever reason says.
+func (c *ArcCache) Remove(ctx context.Context, key string, reason EvictReason) bool {
+	c.Lock()
+	defer c.listener.unlock(c)
+
+	item, ok := c.items[key]
+	c.remove(ctx, key, reason)
+	return ok && !item.IsExpired(c.clock)
 }
 
 func (c *ArcCache) Evict(ctx context.Context, count int) {
 	c.Lock()
-	defer c.Unlock()
+	defer c.listener.unlock(c)
+	c.drainReads(ctx)
 
 	c.evict(ctx, count)
 }
 
-func (c *ArcCache) remove(ctx context.Context, key string) bool {
-	delete(c.items, key)
+func (c *ArcCache) Resize(ctx context.Context, capacity int, maxBytes int64) {
+	c.Lock()
+	defer c.listener.unlock(c)
+	c.drainReads(ctx)
+
+	c.cap = capacity
+	if c.part > c.cap {
+		c.part = c.cap
+	}
+	if c.weigher.resize(maxBytes) {
+		for _, item := range c.items {
+			item.cost = c.cost(item.key, item.value)
+			c.used += item.cost
+		}
+	}
+
+	for c.t1.Len()+c.t2.Len() > 0 && (c.t1.Len()+c.t2.Len() > c.cap || c.overflow(0)) {
+		if !c.reclaim(ctx) {
+			c.replace(ctx, "")
+		}
+	}
+	for c.b1.Len() > c.cap {
+		c.b1.RemoveTail()
+	}
+	for c.b2.Len() > c.cap {
+		c.b2.RemoveTail()
+	}
+}
+
+// Range walks t2 before t1, reporting a frequency of 2 for the entries seen
+// at least twice and 1 for the others.
+func (c *ArcCache) Range(ctx context.Context, fn func(Entry) bool) {
+	c.Lock()
+	defer c.Unlock()
+	c.drainReads(ctx)
+
+	for _, part := range []struct {
+		l    *arcList
+		freq uint64
+	}{{&c.t2, 2}, {&c.t1, 1}} {
+		for elt := part.l.l.Front(); elt != nil; elt = elt.Next() {
+			item := c.items[elt.Value.(string)]
+			if item.IsExpired(c.clock) {
+				continue
+			}
+			if !fn(Entry{Key: item.key, Value: item.value, ExpireAt: item.deadline(), LoadTime: item.loadTime, Freq: part.freq}) {
+				return
+			}
+		}
+	}
+}
+
+// Insert adds e to the tail of t2 when its frequency is at least 2, and to
+// the tail of t1 otherwise.
+func (c *ArcCache) Insert(ctx context.Context, e Entry) error {
+	c.Lock()
+	defer c.listener.unlock(c)
+	c.drainReads(ctx)
+
+	if _, ok := c.items[e.Key]; ok {
+		return nil
+	}
+
+	now := c.clock.Now()
+	expireAt, expires := entryDeadline(now, e)
+	if expireAt.Before(now) {
+		return nil
+	}
+
+	cost := c.cost(e.Key, e.Value)
+	if c.tooLarge(cost) {
+		return ValueTooLargeError
+	}
+
+	if elt := c.b1.Lookup(e.Key); elt != nil {
+		c.b1.Remove(e.Key, elt)
+	}
+	if elt := c.b2.Lookup(e.Key); elt != nil {
+		c.b2.Remove(e.Key, elt)
+	}
+	if !c.fits(ctx, cost) {
+		return ShardFullError
+	}
+	if e.Freq >= 2 {
+		c.t2.PushBack(e.Key)
+	} else {
+		c.t1.PushBack(e.Key)
+	}
+
+	item := &arcItem{
+		expiration: expiration{key: e.Key, loadTime: e.LoadTime},
+		value:      e.Value,
+		cost:       cost,
+	}
+	c.items[e.Key] = item
+	c.expiry.set(&item.expiration, expireAt, expires)
+	c.used += cost
+	return nil
+}
+
+func (c *ArcCache) remove(ctx context.Context, key string, reason EvictReason) bool {
 	if elt := c.b1.Lookup(key); elt != nil {
 		c.b1.Remove(key, elt)
-		return true
-	}
-	if elt := c.t1.Lookup(key); elt != nil {
-		c.t1.Remove(key, elt)
-		return true
 	}
 	if elt := c.b2.Lookup(key); elt != nil {
 		c.b2.Remove(key, elt)
-		return true
+	}
+
+	item, ok := c.items[key]
+	if !ok {
+		return false
+	}
+
+	if elt := c.t1.Lookup(key); elt != nil {
+		c.t1.Remove(key, elt)
 	}
 	if elt := c.t2.Lookup(key); elt != nil {
 		c.t2.Remove(key, elt)
-		return true
 	}
+	delete(c.items, key)
+	c.expiry.remove(&item.expiration)
+	c.used -= item.cost
+	reason = evictReason(c.clock, &item.expiration, reason)
+	c.counters.evicted(reason)
+	c.listener.add(&item.expiration, item.value, reason)
+	return true
+}
 
-	return false
+func (c *ArcCache) Sweep(ctx context.Context) int {
+	c.Lock()
+	defer c.listener.unlock(c)
+
+	n := 0
+	now := c.clock.Now()
+	for e := c.expiry.expired(now); e != nil; e = c.expiry.expired(now) {
+		c.remove(ctx, e.key, EvictExpired)
+		n++
+	}
+	return n
 }
 
 func (c *ArcCache) evict(ctx context.Context, count int) {
-	if !c.isCacheFull() && c.t1.Len()+c.t2.Len() < c.cap {
+	if !c.isCacheFull() && !c.overflow(0) {
 		return
 	}
 
-	cnt := 0
-	for {
-		if cnt >= count {
-			break
+	for i := 0; i < count && c.t1.Len()+c.t2.Len() > 0; i++ {
+		if !c.reclaim(ctx) {
+			c.replace(ctx, "")
 		}
+	}
+}
 
-		if c.isCacheFull() && c.t1.Len()+c.b1.Len() == c.cap {
-			if c.t1.Len() < c.cap {
-				if c.b1.Len() > 0 {
-					pop := c.b1.RemoveTail()
-					delete(c.items, pop)
-					cnt++
-				}
-			} else {
-				pop := c.t1.RemoveTail()
-				delete(c.items, pop)
-				cnt++
-			}
-		} else {
-			total := c.t1.Len() + c.b1.Len() + c.t2.Len() + c.b2.Len()
-			if total == c.cap<<1 {
-				if c.b2.Len() > 0 {
-					pop := c.b2.RemoveTail()
-					delete(c.items, pop)
-					cnt++
-					continue
-				}
-				if c.b1.Len() > 0 {
-					pop := c.b1.RemoveTail()
-					delete(c.items, pop)
-					cnt++
-				}
-			}
-		}
+// reclaim removes an expired resident entry, expired entries don't deserve a
+// place in the ghost lists.
+func (c *ArcCache) reclaim(ctx context.Context) bool {
+	if e := c.expiry.expired(c.clock.Now()); e != nil {
+		return c.remove(ctx, e.key, EvictExpired)
 	}
+	return false
 }
 
-func (c *ArcCache) update(ctx context.Context, key string) {
-	if e := c.b1.Lookup(key); e != nil {
-		c.b1.Remove(key, e)
-		c.t1.PushFront(key)
+// admit makes room for key which is not resident, adapting part when key is
+// found in one of the ghost lists.
+func (c *ArcCache) admit(ctx context.Context, key string, cost int64) {
+	if elt := c.b1.Lookup(key); elt != nil {
+		delta := 1
+		if c.b1.Len() < c.b2.Len() {
+			delta = c.b2.Len() / c.b1.Len()
+		}
+		c.part += delta
+		if c.part > c.cap {
+			c.part = c.cap
+		}
+		c.b1.Remove(key, elt)
+		c.makeRoom(ctx, key, cost)
+		c.t2.PushFront(key)
+		return
+	}
+
+	if elt := c.b2.Lookup(key); elt != nil {
+		delta := 1
+		if c.b2.Len() < c.b1.Len() {
+			delta = c.b1.Len() / c.b2.Len()
+		}
+		c.part -= delta
+		if c.part < 0 {
+			c.part = 0
+		}
+		c.b2.Remove(key, elt)
+		c.makeRoom(ctx, key, cost)
+		c.t2.PushFront(key)
 		return
 	}
 
+	if c.t1.Len()+c.b1.Len() >= c.cap && c.b1.Len() > 0 {
+		c.b1.RemoveTail()
+	} else if total := c.t1.Len() + c.b1.Len() + c.t2.Len() + c.b2.Len(); total >= c.cap<<1 && c.b2.Len() > 0 {
+		c.b2.RemoveTail()
+	}
+	c.makeRoom(ctx, key, cost)
+	c.t1.PushFront(key)
+}
+
+// makeRoom demotes resident entries to the ghost lists until an entry
+// weighing cost fits.
+func (c *ArcCache) makeRoom(ctx context.Context, key string, cost int64) {
+	for c.t1.Len()+c.t2.Len() > 0 && (c.isCacheFull() || c.overflow(cost)) {
+		if !c.reclaim(ctx) {
+			c.replace(ctx, key)
+		}
+	}
+}
+
+// fits removes expired entries until an entry weighing cost fits, and reports
+// whether it does without evicting a live one.
+func (c *ArcCache) fits(ctx context.Context, cost int64) bool {
+	for c.isCacheFull() || c.overflow(cost) {
+		if !c.reclaim(ctx) {
+			return false
+		}
+	}
+	return true
+}
+
+// replace demotes the tail of t1 or t2 to its ghost list, following part. The
+// entry of key, being set, is only demoted if it is the last one.
+func (c *ArcCache) replace(ctx context.Context, key string) {
+	var (
+		from  = &c.t2
+		ghost = &c.b2
+	)
+	if c.t1.Len() > 0 && (c.t1.Len() > c.part || (c.t1.Len() == c.part && c.b2.Has(key)) || c.t2.Len() == 0) {
+		from = &c.t1
+		ghost = &c.b1
+	}
+	if from.Back() == key && c.t1.Len() > 0 && c.t2.Len() > 0 {
+		if from == &c.t1 {
+			from, ghost = &c.t2, &c.b2
+		} else {
+			from, ghost = &c.t1, &c.b1
+		}
+	}
+
+	pop := from.RemoveTail()
+	if item, ok := c.items[pop]; ok {
+		delete(c.items, pop)
+		c.expiry.remove(&item.expiration)
+		c.used -= item.cost
+		reason := evictReason(c.clock, &item.expiration, EvictCapacity)
+		c.counters.evicted(reason)
+		c.listener.add(&item.expiration, item.value, reason)
+	}
+	ghost.PushFront(pop)
+
+	for c.b1.Len() > c.cap {
+		c.b1.RemoveTail()
+	}
+	for c.b2.Len() > c.cap {
+		c.b2.RemoveTail()
+	}
+}
+
+func (c *ArcCache) Stats() ShardStats {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.counters.stats(len(c.items))
+}
+
+// drainReads replays the buffered hits, skipping entries removed since.
+func (c *ArcCache) drainReads(ctx context.Context) {
+	c.reads.drain(func(item *arcItem) {
+		if c.items[item.key] == item {
+			c.update(ctx, item.key)
+		}
+	})
+}
+
+// update records a hit on a resident key.
+func (c *ArcCache) update(ctx context.Context, key string) {
 	if e := c.t1.Lookup(key); e != nil {
 		c.t1.Remove(key, e)
 		c.t2.PushFront(key)
@@ -195,31 +453,17 @@ func (c *ArcCache) update(ctx context.Context, key string) {
 
 	if e := c.t2.Lookup(key); e != nil {
 		c.t2.MoveToFront(e)
-		return
-	}
-
-	if e := c.b2.Lookup(key); e != nil {
-		c.b2.Remove(key, e)
-		c.t1.PushFront(key)
-		if c.isCacheFull() && c.t1.Len() > 0 {
-			pop := c.t1.RemoveTail()
-			c.b1.PushFront(pop)
-		}
 	}
 }
 
 func (c *ArcCache) isCacheFull() bool {
-	return (c.t1.Len() + c.t2.Len()) == c.cap
+	return c.t1.Len()+c.t2.Len() >= c.cap
 }
 
 type arcItem struct {
-	key      string
-	value    interface{}
-	expireAt time.Time
-}
-
-func (it *arcItem) IsExpired(clock Clock) bool {
-	return it.expireAt.Before(clock.Now())
+	expiration
+	value interface{}
+	cost  int64
 }
 
 type arcList struct {
@@ -257,11 +501,27 @@ func (al *arcList) PushFront(key string) {
 	al.keys[key] = elt
 }
 
+func (al *arcList) PushBack(key string) {
+	if _, ok := al.keys[key]; ok {
+		return
+	}
+	elt := al.l.PushBack(key)
+	al.keys[key] = elt
+}
+
 func (al *arcList) Remove(key string, elt *list.Element) {
 	delete(al.keys, key)
 	al.l.Remove(elt)
 }
 
+// Back returns the key at the tail, "" if the list is empty.
+func (al *arcList) Back() string {
+	if elt := al.l.Back(); elt != nil {
+		return elt.Value.(string)
+	}
+	return ""
+}
+
 func (al *arcList) RemoveTail() string {
 	elt := al.l.Back()
 	al.l.Remove(elt)
@@ -0,0 +1,74 @@
+package mcache
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+// Run with -cpu=1,2,4,8 to see how hits scale with GOMAXPROCS.
+
+func BenchmarkPolicyGet(b *testing.B) {
+	b.Run("simple cache", benchPolicyGet[SimpleCache])
+	b.Run("lfu cache", benchPolicyGet[LfuCache])
+	b.Run("lru cache", benchPolicyGet[LruCache])
+	b.Run("arc cache", benchPolicyGet[ArcCache])
+	b.Run("adaptive cache", benchPolicyGet[AdaptiveCache])
+}
+
+// benchPolicyGet hammers a single shard with hits.
+func benchPolicyGet[T any, P CachePolicy[T]](b *testing.B) {
+	const n = 1 << 10
+
+	ctx := context.TODO()
+	cc := P(new(T))
+	cc.Init(NewRealClock(), n)
+	keys := benchKeys(n)
+	for _, key := range keys {
+		cc.Set(ctx, key, key, 0)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			cc.Get(ctx, keys[i&(n-1)])
+		}
+	})
+}
+
+func BenchmarkCacheGet(b *testing.B) {
+	b.Run("simple cache", benchCacheGet[SimpleCache])
+	b.Run("lfu cache", benchCacheGet[LfuCache])
+	b.Run("lru cache", benchCacheGet[LruCache])
+	b.Run("arc cache", benchCacheGet[ArcCache])
+	b.Run("adaptive cache", benchCacheGet[AdaptiveCache])
+}
+
+func benchCacheGet[T any, P CachePolicy[T]](b *testing.B) {
+	const n = 1 << 14
+
+	ctx := context.TODO()
+	cc := New[T, P](n)
+	defer cc.Close()
+	keys := benchKeys(n)
+	for _, key := range keys {
+		cc.Set(ctx, key, key)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			cc.Get(ctx, keys[i&(n-1)])
+		}
+	})
+}
+
+func benchKeys(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%d", i)
+	}
+	return keys
+}
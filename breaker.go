@@ -0,0 +1,276 @@
+package mcache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	defaultBreakerWindow        = 10 * time.Second //默认的失败率统计窗口
+	defaultBreakerMinRequests   = 20               //窗口内触发熔断的最少调用数
+	defaultBreakerFailureRate   = 0.5              //默认的熔断失败率
+	defaultBreakerOpenTimeout   = 5 * time.Second  //熔断后开始探测前的默认等待
+	defaultBreakerProbes        = 1                //半开状态默认的探测调用数
+	defaultBreakerInvalidations = 1 << 14          //熔断期间默认的最大待删除键数
+)
+
+// CircuitBreaker configures the breaker of WithCircuitBreaker, a zero field
+// takes its default.
+type CircuitBreaker struct {
+	// Window is the period the calls to Redis are counted over, 10s by
+	// default.
+	Window time.Duration
+	// MinRequests is the number of calls in a window before the circuit can
+	// open, 20 by default.
+	MinRequests int
+	// FailureRate opens the circuit once that share of the calls of a window
+	// failed, 0.5 by default.
+	FailureRate float64
+	// SlowCall counts the calls lasting longer as failures, 0 disables it.
+	SlowCall time.Duration
+	// OpenTimeout is how long the circuit stays open before Redis is probed,
+	// 5s by default.
+	OpenTimeout time.Duration
+	// HalfOpenProbes is the number of calls let through to probe Redis, the
+	// circuit closes once they all succeed, 1 by default.
+	HalfOpenProbes int
+	// MaxInvalidations bounds the deletes queued while the circuit is open,
+	// 16384 by default. The deletes of the keys over are dropped.
+	MaxInvalidations int
+}
+
+func (cb *CircuitBreaker) withDefaults() {
+	if cb.Window <= 0 {
+		cb.Window = defaultBreakerWindow
+	}
+	if cb.MinRequests <= 0 {
+		cb.MinRequests = defaultBreakerMinRequests
+	}
+	if cb.FailureRate <= 0 {
+		cb.FailureRate = defaultBreakerFailureRate
+	}
+	if cb.OpenTimeout <= 0 {
+		cb.OpenTimeout = defaultBreakerOpenTimeout
+	}
+	if cb.HalfOpenProbes <= 0 {
+		cb.HalfOpenProbes = defaultBreakerProbes
+	}
+	if cb.MaxInvalidations <= 0 {
+		cb.MaxInvalidations = defaultBreakerInvalidations
+	}
+}
+
+// BreakerState is the state of the circuit breaker.
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota //正常调用Redis
+	BreakerOpen                         //熔断, 只使用本地分片
+	BreakerHalfOpen                     //放行少量调用探测Redis
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// BreakerStats describes the breaker of WithCircuitBreaker.
+type BreakerStats struct {
+	State BreakerState
+	// Trips counts the times the circuit opened, Rejected the calls to Redis
+	// it short-circuited.
+	Trips    uint64
+	Rejected uint64
+	// Invalidations is the number of deletes queued to replay, Dropped counts
+	// the ones given up on.
+	Invalidations int
+	Dropped       uint64
+}
+
+// breaker is a circuit breaker around the calls to Redis. While the circuit
+// is open the cache only uses the local shards, and the keys written or
+// removed meanwhile are deleted from Redis once it closes.
+type breaker struct {
+	cfg    CircuitBreaker
+	clock  Clock
+	replay func(ctx context.Context, keys []string) error
+
+	mu          sync.Mutex
+	state       BreakerState
+	openedAt    time.Time
+	windowStart time.Time
+	calls       int
+	failures    int
+	probes      int // calls let through while half-open
+	probed      int // of which succeeded
+
+	invalidations map[string]struct{}
+	replaying     bool
+
+	trips    uint64
+	rejected uint64
+	dropped  uint64
+}
+
+func newBreaker(cfg CircuitBreaker, clock Clock) *breaker {
+	cfg.withDefaults()
+	return &breaker{
+		cfg:           cfg,
+		clock:         clock,
+		windowStart:   clock.Now(),
+		invalidations: make(map[string]struct{}),
+	}
+}
+
+// allow reports whether a call to Redis may go on, every call allowed must be
+// reported to done.
+func (b *breaker) allow() bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if b.clock.Now().Sub(b.openedAt) < b.cfg.OpenTimeout {
+			break
+		}
+		b.state = BreakerHalfOpen
+		b.probes, b.probed = 0, 0
+		fallthrough
+	case BreakerHalfOpen:
+		if b.probes >= b.cfg.HalfOpenProbes {
+			break
+		}
+		b.probes++
+		return true
+	default:
+		return true
+	}
+
+	atomic.AddUint64(&b.rejected, 1)
+	return false
+}
+
+// done records the outcome of a call allowed, lasting d.
+func (b *breaker) done(d time.Duration, err error) {
+	if b == nil {
+		return
+	}
+
+	failed := err != nil && err != redis.Nil && err != KeyNotFoundError && !errors.Is(err, context.Canceled)
+	if b.cfg.SlowCall > 0 && d > b.cfg.SlowCall {
+		failed = true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.clock.Now()
+	switch b.state {
+	case BreakerHalfOpen:
+		if failed {
+			b.trip(now)
+			return
+		}
+		if b.probed++; b.probed >= b.cfg.HalfOpenProbes {
+			b.state = BreakerClosed
+			b.windowStart, b.calls, b.failures = now, 0, 0
+			b.startReplay()
+		}
+	case BreakerClosed:
+		if now.Sub(b.windowStart) >= b.cfg.Window {
+			b.windowStart, b.calls, b.failures = now, 0, 0
+		}
+		b.calls++
+		if failed {
+			b.failures++
+		}
+		if b.calls >= b.cfg.MinRequests && float64(b.failures) >= b.cfg.FailureRate*float64(b.calls) {
+			b.trip(now)
+		} else if !failed {
+			b.startReplay()
+		}
+	}
+}
+
+func (b *breaker) trip(now time.Time) {
+	b.state = BreakerOpen
+	b.openedAt = now
+	atomic.AddUint64(&b.trips, 1)
+}
+
+// invalidate queues the deletes of keys written or removed without Redis.
+func (b *breaker) invalidate(keys []string) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, key := range keys {
+		if _, ok := b.invalidations[key]; !ok && len(b.invalidations) >= b.cfg.MaxInvalidations {
+			atomic.AddUint64(&b.dropped, 1)
+			continue
+		}
+		b.invalidations[key] = struct{}{}
+	}
+}
+
+// startReplay deletes the queued keys in the background, b.mu is held.
+func (b *breaker) startReplay() {
+	if b.replaying || len(b.invalidations) == 0 || b.replay == nil {
+		return
+	}
+	b.replaying = true
+	keys := make([]string, 0, len(b.invalidations))
+	for key := range b.invalidations {
+		keys = append(keys, key)
+	}
+	b.invalidations = make(map[string]struct{}, len(keys))
+
+	go func() {
+		err := b.replay(context.Background(), keys)
+
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.replaying = false
+		if err != nil {
+			// a failed replay waits for the next successful call.
+			for _, key := range keys {
+				b.invalidations[key] = struct{}{}
+			}
+		}
+	}()
+}
+
+func (b *breaker) stats() BreakerStats {
+	if b == nil {
+		return BreakerStats{}
+	}
+
+	b.mu.Lock()
+	s := BreakerStats{State: b.state, Invalidations: len(b.invalidations)}
+	b.mu.Unlock()
+
+	s.Trips = atomic.LoadUint64(&b.trips)
+	s.Rejected = atomic.LoadUint64(&b.rejected)
+	s.Dropped = atomic.LoadUint64(&b.dropped)
+	return s
+}
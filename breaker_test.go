@@ -0,0 +1,140 @@
+package mcache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBreaker(t *testing.T) {
+	fc := NewFakeClock()
+	b := newBreaker(CircuitBreaker{MinRequests: 4, SlowCall: time.Second, OpenTimeout: time.Second, HalfOpenProbes: 2}, fc)
+	failure := errors.New("failure")
+
+	// misses are no failures, slow calls are.
+	for i := 0; i < 3; i++ {
+		assert.True(t, b.allow())
+		b.done(time.Millisecond, redis.Nil)
+	}
+	assert.True(t, b.allow())
+	b.done(2*time.Second, nil)
+	assert.Equal(t, BreakerClosed, b.stats().State)
+
+	// the window restarts, half of its calls fail.
+	fc.Advance(defaultBreakerWindow)
+	for _, err := range []error{nil, failure, nil, failure} {
+		assert.True(t, b.allow())
+		b.done(time.Millisecond, err)
+	}
+	assert.Equal(t, BreakerOpen, b.stats().State)
+	assert.False(t, b.allow())
+
+	// a failed probe opens the circuit again, successful probes close it.
+	fc.Advance(time.Second)
+	assert.True(t, b.allow())
+	b.done(time.Millisecond, failure)
+	assert.False(t, b.allow())
+	fc.Advance(time.Second)
+	assert.True(t, b.allow())
+	assert.True(t, b.allow())
+	assert.False(t, b.allow())
+	b.done(time.Millisecond, nil)
+	assert.Equal(t, BreakerHalfOpen, b.stats().State)
+	b.done(time.Millisecond, nil)
+
+	s := b.stats()
+	assert.Equal(t, BreakerClosed, s.State)
+	assert.Equal(t, uint64(2), s.Trips)
+	assert.Equal(t, uint64(3), s.Rejected)
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.Nil(t, err)
+	defer mr.Close()
+
+	var (
+		ctx    = context.TODO()
+		fc     = NewFakeClock()
+		client = redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+		codec  = WithUnSafeValBind(func() interface{} { return new(string) })
+		cfg    = CircuitBreaker{MinRequests: 2, OpenTimeout: time.Second}
+		c      = New[LruCache](64, WithRedisClient(client), codec, WithClock(fc), WithCircuitBreaker(cfg))
+	)
+	defer c.Close()
+
+	// the second call of the window fails, the circuit opens.
+	assert.Nil(t, c.Set(ctx, "a", "1"))
+	mr.Close()
+	assert.NotNil(t, c.Set(ctx, "b", "2"))
+	assert.Equal(t, BreakerOpen, c.Stats().Breaker.State)
+
+	// the open circuit falls back to the local shards.
+	assert.Nil(t, c.Set(ctx, "b", "2"))
+	assert.True(t, c.Remove(ctx, "a"))
+	_, err = c.Get(ctx, "a")
+	assert.Equal(t, KeyNotFoundError, err)
+	val, err := c.Get(ctx, "b")
+	assert.Nil(t, err)
+	assert.Equal(t, "2", val)
+	assert.Equal(t, 2, c.Stats().Breaker.Invalidations)
+
+	// once the circuit closes the keys written meanwhile are deleted.
+	assert.Nil(t, mr.Restart())
+	fc.Advance(time.Second)
+	assert.Nil(t, c.Set(ctx, "c", "3"))
+	assert.Eventually(t, func() bool { return !mr.Exists("a") }, time.Second, time.Millisecond)
+	assert.True(t, mr.Exists("c"))
+	s := c.Stats().Breaker
+	assert.Equal(t, BreakerClosed, s.State)
+	assert.Equal(t, uint64(1), s.Trips)
+}
+
+func TestCircuitBreakerWriteBehind(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.Nil(t, err)
+	defer mr.Close()
+
+	var (
+		ctx      = context.TODO()
+		fc       = NewFakeClock()
+		client   = redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+		codec    = WithUnSafeValBind(func() interface{} { return new(string) })
+		interval = time.Second
+		wb       = WriteBehind{Interval: interval, MaxRetries: 1}
+		cfg      = CircuitBreaker{MinRequests: 2, OpenTimeout: time.Hour}
+		c        = New[LruCache](64, WithRedisClient(client), codec, WithClock(fc), WithWriteBehind(wb), WithCircuitBreaker(cfg))
+	)
+	defer c.Close()
+	tick := func(cond func() bool) {
+		assert.Eventually(t, func() bool {
+			fc.Advance(interval)
+			return cond()
+		}, time.Second, time.Millisecond)
+	}
+
+	assert.Nil(t, c.Set(ctx, "a", "old"))
+	tick(func() bool { return mr.Exists("a") })
+
+	// the flush of the new value fails and opens the circuit, its retry is
+	// rejected and dropped.
+	mr.Close()
+	assert.Nil(t, c.Set(ctx, "a", "new"))
+	tick(func() bool { return c.Stats().WriteBehind.Dropped == 1 })
+	assert.Nil(t, mr.Restart())
+	assert.Equal(t, BreakerOpen, c.Stats().Breaker.State)
+	assert.Equal(t, 1, c.Stats().Breaker.Invalidations)
+	assert.True(t, mr.Exists("a"))
+
+	// once the circuit closes the old value is deleted.
+	fc.Advance(time.Hour)
+	_, err = c.Get(ctx, "missing")
+	assert.Equal(t, KeyNotFoundError, err)
+	assert.Eventually(t, func() bool { return !mr.Exists("a") }, time.Second, time.Millisecond)
+	assert.Equal(t, BreakerClosed, c.Stats().Breaker.State)
+}
@@ -3,6 +3,8 @@ package mcache
 import (
 	"context"
 	"errors"
+	"io"
+	"time"
 )
 
 var (
@@ -12,6 +14,14 @@ var (
 	SerializeError       = errors.New("mcache: must set WithSafeValPtrFunc option!")
 	KeyValueLenError     = errors.New("mcache: len of key != len of value.")
 	DefaultValueSetError = errors.New("mcache: set def val, 1min expiration.")
+	ValueTooLargeError   = errors.New("mcache: value exceeds shard byte budget.")
+	DuplicateCacheError  = errors.New("mcache: cache name already registered.")
+	SnapshotFormatError  = errors.New("mcache: invalid or truncated snapshot.")
+	DiskClosedError      = errors.New("mcache: disk store closed.")
+	DiskChecksumError    = errors.New("mcache: disk record corrupted.")
+	CircuitOpenError     = errors.New("mcache: redis circuit open.")
+	StaleValueError      = errors.New("mcache: stale value served, not cached.")
+	ShardFullError       = errors.New("mcache: shard full of live entries.")
 )
 
 type Cache interface {
@@ -21,27 +31,51 @@ type Cache interface {
 	Get(ctx context.Context, key string, opts ...Option) (interface{}, error)
 	MGet(ctx context.Context, keys []string, opts ...Option) (map[string]interface{}, error)
 
-	Remove(ctx context.Context, key string) bool
-	MRemove(ctx context.Context, keys []string) bool
+	Remove(ctx context.Context, key string, opts ...Option) bool
+	MRemove(ctx context.Context, keys []string, opts ...Option) bool
 	Exists(ctx context.Context, key string) bool
+	// Invalidate removes keys from every tier, retrying the failed Redis
+	// deletes in the background.
+	Invalidate(ctx context.Context, keys []string, opts ...Option) error
+
+	// Resize changes the local capacity, and the byte budget and number of
+	// shards with WithMaxBytes and WithShardCount, while the cache is in use.
+	Resize(ctx context.Context, size int, opts ...Option) error
+	// Stats returns the counters of the cache since it was created.
+	Stats() Stats
+
+	// Snapshot writes the live local entries to w, Restore adds them back to
+	// the local shards of a cache, e.g. to start a new process warm.
+	Snapshot(ctx context.Context, w io.Writer) error
+	Restore(ctx context.Context, r io.Reader) error
+	// ServeHandoff hands the live local entries to a new process calling
+	// ReceiveHandoff on the Unix socket path.
+	ServeHandoff(ctx context.Context, path string, opts ...Option) error
+	ReceiveHandoff(ctx context.Context, path string, opts ...Option) error
+
+	Close() error
 
 	//only for debug
 	DebugShardIndex(key string) uint64
 	debugLocalGet(ctx context.Context, key string) (interface{}, error)
 	debugLocalRemove(ctx context.Context, key string) bool
 	serializer
+	// localTTL returns the time the live local entry of key has left, zero
+	// without a TTL.
+	localTTL(ctx context.Context, key string) (time.Duration, bool)
 }
 
 type (
 	LoaderFunc  func(context.Context, string) (interface{}, error)
 	MLoaderFunc func(context.Context, []string) (map[string]interface{}, error)
+	Hasher      func(string) uint64
 
 	valuePtrFunc func() interface{}
 )
 
 const (
-	defaultCacheSize  = 1 << 7                  //默认缓存容量
-	defaultShardCap   = 1 << 6                  //默认单片容量
-	defaultShardCount = 1 << 5                  //默认分片数量
-	defaultExpiredAt  = 100 * 365 * 24 * 3600e9 //100years
+	defaultCacheSize     = 1 << 7                  //默认缓存容量
+	defaultShardCap      = 1 << 6                  //默认单片最小容量
+	defaultShardsPerProc = 1 << 2                  //每个P默认的分片数量
+	defaultExpiredAt     = 100 * 365 * 24 * 3600e9 //100years
 )
@@ -0,0 +1,526 @@
+package mcache
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Tier is a level of a Chain, e.g. a local cache, a disk store or Redis. A key
+// a tier doesn't hold is reported by Get with KeyNotFoundError and left out of
+// the result of MGet. The options of a call, e.g. WithTTL, are passed on to
+// the tiers, never the loader functions.
+type Tier interface {
+	Get(ctx context.Context, key string, opts ...Option) (TierValue, error)
+	MGet(ctx context.Context, keys []string, opts ...Option) (map[string]TierValue, error)
+	Set(ctx context.Context, key string, value interface{}, opts ...Option) error
+	MSet(ctx context.Context, keys []string, values []interface{}, opts ...Option) error
+	Remove(ctx context.Context, keys ...string) error
+}
+
+// TierValue is a value found in a tier with the time it has left there, zero
+// if it never expires.
+type TierValue struct {
+	Value interface{}
+	TTL   time.Duration
+}
+
+// Propagation is what Set and MSet of a Chain do to a tier.
+type Propagation int
+
+const (
+	PropagateWrite      Propagation = iota //写入该层
+	PropagateInvalidate                    //删除该层的旧值
+	PropagateNone                          //不改动该层, 只有Remove会删除
+)
+
+type TierOption func(*tierOptions)
+
+type tierOptions struct {
+	SkipRead     bool
+	SkipBackfill bool
+	Propagation  Propagation
+}
+
+// WithReadThrough sets whether Get and MGet look a key up in the tier, true
+// by default. A tier not read still gets the writes.
+func WithReadThrough(read bool) TierOption {
+	return func(o *tierOptions) {
+		o.SkipRead = !read
+	}
+}
+
+// WithBackfill sets whether the values found in a lower tier, or loaded, are
+// added to the tier, true by default.
+func WithBackfill(backfill bool) TierOption {
+	return func(o *tierOptions) {
+		o.SkipBackfill = !backfill
+	}
+}
+
+// WithPropagation sets what the writes do to the tier, PropagateWrite by
+// default.
+func WithPropagation(p Propagation) TierOption {
+	return func(o *tierOptions) {
+		o.Propagation = p
+	}
+}
+
+type configuredTier struct {
+	Tier
+	opts tierOptions
+}
+
+// ConfigureTier returns t with the rules opts, for NewChain.
+func ConfigureTier(t Tier, opts ...TierOption) Tier {
+	c := configuredTier{Tier: t}
+	if ct, ok := t.(configuredTier); ok {
+		c = ct
+	}
+	for _, opt := range opts {
+		opt(&c.opts)
+	}
+	return c
+}
+
+// Chain looks keys up in a list of tiers, from the first to the last, and
+// with the loader functions of the call after the last one.
+type Chain struct {
+	tiers []configuredTier
+}
+
+// NewChain returns a chain of tiers, the first one is looked up first. A hit
+// in a tier, or a loaded value, is added to the tiers above it, and writes go
+// to every tier, the last one first, unless ConfigureTier says otherwise.
+func NewChain(tiers ...Tier) *Chain {
+	c := &Chain{tiers: make([]configuredTier, 0, len(tiers))}
+	for _, t := range tiers {
+		c.tiers = append(c.tiers, ConfigureTier(t).(configuredTier))
+	}
+	return c
+}
+
+// tierOpts returns the options of the call without its loader functions, for
+// the tiers.
+func tierOpts(opts []Option) []Option {
+	return append(opts[:len(opts):len(opts)], func(o *options) {
+		o.LoaderFunc = nil
+		o.MLoaderFunc = nil
+	})
+}
+
+// Get returns the value of key from the first tier holding it, or from the
+// loader function of the call.
+func (c *Chain) Get(ctx context.Context, key string, opts ...Option) (interface{}, error) {
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	topts := tierOpts(opts)
+
+	for i, t := range c.tiers {
+		if t.opts.SkipRead {
+			continue
+		}
+		if tv, err := t.Get(ctx, key, topts...); err == nil {
+			c.backfill(ctx, i, []string{key}, []TierValue{tv}, topts)
+			return tv.Value, nil
+		}
+	}
+	if o.LoaderFunc == nil {
+		return nil, KeyNotFoundError
+	}
+
+	val, err := o.LoaderFunc(ctx, key)
+	if err != nil {
+		if o.DefaultVal == nil {
+			return nil, err
+		}
+		val, err = o.DefaultVal, DefaultValueSetError
+		topts = append(topts, WithTTL(time.Minute))
+	}
+	c.backfill(ctx, len(c.tiers), []string{key}, []TierValue{{Value: val}}, topts)
+	return val, err
+}
+
+// MGet returns the values of keys found in the tiers or loaded by the loader
+// function of the call, a key found nowhere is left out.
+func (c *Chain) MGet(ctx context.Context, keys []string, opts ...Option) (map[string]interface{}, error) {
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	topts := tierOpts(opts)
+
+	res := make(map[string]interface{}, len(keys))
+	miss := keys
+	for i, t := range c.tiers {
+		if len(miss) == 0 {
+			break
+		}
+		if t.opts.SkipRead {
+			continue
+		}
+		kvs, _ := t.MGet(ctx, miss, topts...)
+		if len(kvs) == 0 {
+			continue
+		}
+		miss = c.collect(ctx, i, miss, kvs, res, topts)
+	}
+
+	if len(miss) > 0 && o.MLoaderFunc != nil {
+		if kvs, err := o.MLoaderFunc(ctx, miss); err == nil {
+			loaded := make(map[string]TierValue, len(kvs))
+			for key, val := range kvs {
+				loaded[key] = TierValue{Value: val}
+			}
+			c.collect(ctx, len(c.tiers), miss, loaded, res, topts)
+		}
+	}
+	return res, nil
+}
+
+// collect adds the values of kvs found below the i-th tier to res and to the
+// tiers above, it returns the keys still missing.
+func (c *Chain) collect(ctx context.Context, i int, miss []string, kvs map[string]TierValue, res map[string]interface{}, opts []Option) []string {
+	hits := make([]string, 0, len(kvs))
+	vals := make([]TierValue, 0, len(kvs))
+	rest := make([]string, 0, len(miss))
+	for _, key := range miss {
+		if tv, ok := kvs[key]; ok {
+			res[key] = tv.Value
+			hits = append(hits, key)
+			vals = append(vals, tv)
+		} else {
+			rest = append(rest, key)
+		}
+	}
+	c.backfill(ctx, i, hits, vals, opts)
+	return rest
+}
+
+// backfill adds the values found below the i-th tier to the tiers above it,
+// for the TTL of the call but never longer than they have left below. A tier
+// failing to keep them is only a later miss.
+func (c *Chain) backfill(ctx context.Context, i int, keys []string, values []TierValue, opts []Option) {
+	if len(keys) == 0 {
+		return
+	}
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	var (
+		plainKeys = make([]string, 0, len(keys))
+		plainVals = make([]interface{}, 0, len(keys))
+		capped    []int
+		cappedOpt [][]Option
+	)
+	for j, key := range keys {
+		left := values[j].TTL
+		if ttl := o.ttlOf(key); left <= 0 || (ttl > 0 && ttl <= left) {
+			plainKeys = append(plainKeys, key)
+			plainVals = append(plainVals, values[j].Value)
+			continue
+		}
+		capped = append(capped, j)
+		cappedOpt = append(cappedOpt, append(opts[:len(opts):len(opts)], func(o *options) {
+			o.TTL, o.TTLJitter = left, 0
+		}))
+	}
+
+	for _, t := range c.tiers[:i] {
+		if t.opts.SkipBackfill {
+			continue
+		}
+		if len(plainKeys) == 1 {
+			t.Set(ctx, plainKeys[0], plainVals[0], opts...)
+		} else if len(plainKeys) > 1 {
+			t.MSet(ctx, plainKeys, plainVals, opts...)
+		}
+		for n, j := range capped {
+			t.Set(ctx, keys[j], values[j].Value, cappedOpt[n]...)
+		}
+	}
+}
+
+// Set writes key to the tiers, the last one first. It stops at the first tier
+// failing, so the tiers above it keep the previous value.
+func (c *Chain) Set(ctx context.Context, key string, value interface{}, opts ...Option) error {
+	return c.MSet(ctx, []string{key}, []interface{}{value}, opts...)
+}
+
+// MSet writes keys to the tiers like Set.
+func (c *Chain) MSet(ctx context.Context, keys []string, values []interface{}, opts ...Option) error {
+	if len(keys) != len(values) {
+		return KeyValueLenError
+	}
+	topts := tierOpts(opts)
+
+	for i := len(c.tiers) - 1; i >= 0; i-- {
+		var (
+			t   = c.tiers[i]
+			err error
+		)
+		switch t.opts.Propagation {
+		case PropagateWrite:
+			if len(keys) == 1 {
+				err = t.Set(ctx, keys[0], values[0], topts...)
+			} else {
+				err = t.MSet(ctx, keys, values, topts...)
+			}
+		case PropagateInvalidate:
+			err = t.Remove(ctx, keys...)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Remove deletes keys from every tier, the last one first. A tier failing
+// doesn't keep keys in the tiers above, the first error is returned.
+func (c *Chain) Remove(ctx context.Context, keys ...string) error {
+	var first error
+	for i := len(c.tiers) - 1; i >= 0; i-- {
+		if err := c.tiers[i].Remove(ctx, keys...); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+type cacheTier struct {
+	Cache
+}
+
+// CacheTier returns c as a tier. A tier cache is usually built without a
+// remote store or loader functions, which the chain provides.
+func CacheTier(c Cache) Tier {
+	return cacheTier{Cache: c}
+}
+
+// Get returns the value of key with the time its local entry has left, or
+// with the TTL of the call if the cache doesn't keep it locally.
+func (t cacheTier) Get(ctx context.Context, key string, opts ...Option) (TierValue, error) {
+	val, err := t.Cache.Get(ctx, key, opts...)
+	if err != nil {
+		return TierValue{}, err
+	}
+	return TierValue{Value: val, TTL: t.ttl(ctx, key, opts)}, nil
+}
+
+func (t cacheTier) MGet(ctx context.Context, keys []string, opts ...Option) (map[string]TierValue, error) {
+	kvs, err := t.Cache.MGet(ctx, keys, opts...)
+	res := make(map[string]TierValue, len(kvs))
+	for key, val := range kvs {
+		res[key] = TierValue{Value: val, TTL: t.ttl(ctx, key, opts)}
+	}
+	return res, err
+}
+
+func (t cacheTier) ttl(ctx context.Context, key string, opts []Option) time.Duration {
+	if ttl, ok := t.Cache.localTTL(ctx, key); ok {
+		return ttl
+	}
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o.ttlOf(key)
+}
+
+func (t cacheTier) Remove(ctx context.Context, keys ...string) error {
+	if len(keys) == 1 {
+		t.Cache.Remove(ctx, keys[0])
+	} else {
+		t.Cache.MRemove(ctx, keys)
+	}
+	return nil
+}
+
+type diskTier struct {
+	store *DiskStore
+	opts  options
+}
+
+// DiskTier returns store as a tier. The values are encoded with the codec set
+// by opts, which is required, and kept for the TTL of the call or of opts.
+func DiskTier(store *DiskStore, opts ...Option) Tier {
+	t := diskTier{store: store}
+	for _, opt := range opts {
+		opt(&t.opts)
+	}
+	return t
+}
+
+func (t diskTier) options(opts []Option) options {
+	o := t.opts
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+func (t diskTier) Get(ctx context.Context, key string, opts ...Option) (TierValue, error) {
+	o := t.options(opts)
+	if o.deserializeFunc == nil {
+		return TierValue{}, SerializeError
+	}
+	data, expireAt, err := t.store.Get(key)
+	if err != nil {
+		return TierValue{}, err
+	}
+	tv := TierValue{}
+	if !expireAt.IsZero() {
+		if tv.TTL = expireAt.Sub(t.store.opts.clock.Now()); tv.TTL <= 0 {
+			return TierValue{}, KeyNotFoundError
+		}
+	}
+	tv.Value, err = o.deserializeFunc(ctx, data)
+	return tv, err
+}
+
+func (t diskTier) MGet(ctx context.Context, keys []string, opts ...Option) (map[string]TierValue, error) {
+	res := make(map[string]TierValue, len(keys))
+	for _, key := range keys {
+		if tv, err := t.Get(ctx, key, opts...); err == nil {
+			res[key] = tv
+		}
+	}
+	return res, nil
+}
+
+func (t diskTier) Set(ctx context.Context, key string, value interface{}, opts ...Option) error {
+	o := t.options(opts)
+	if o.serializeFunc == nil {
+		return SerializeError
+	}
+	data, err := o.serializeFunc(ctx, value)
+	if err != nil {
+		return err
+	}
+	var expireAt time.Time
+	if ttl := o.ttlOf(key); ttl > 0 {
+		expireAt = t.store.opts.clock.Now().Add(ttl)
+	}
+	return t.store.Set(key, data, expireAt)
+}
+
+func (t diskTier) MSet(ctx context.Context, keys []string, values []interface{}, opts ...Option) error {
+	if len(keys) != len(values) {
+		return KeyValueLenError
+	}
+	for i, key := range keys {
+		if err := t.Set(ctx, key, values[i], opts...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t diskTier) Remove(ctx context.Context, keys ...string) error {
+	for _, key := range keys {
+		if _, err := t.store.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type redisTier struct {
+	cli  RedisCli
+	opts options
+}
+
+// RedisTier returns client as a tier. The values are encoded with the codec
+// set by opts, if any, and kept for the TTL of the call or of opts. The calls
+// are traced with the tracer set by opts.
+func RedisTier(client *redis.Client, opts ...Option) Tier {
+	t := redisTier{}
+	for _, opt := range opts {
+		opt(&t.opts)
+	}
+	t.cli = RedisCli{Client: client, tracer: t.opts.Tracer}
+	return t
+}
+
+func (t redisTier) options(opts []Option) options {
+	o := t.opts
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+func (t redisTier) Get(ctx context.Context, key string, opts ...Option) (TierValue, error) {
+	val, err := t.cli.get(ctx, key, t.options(opts))
+	if err != nil {
+		return TierValue{}, err
+	}
+	res, err := t.withTTL(ctx, map[string]interface{}{key: val})
+	if err != nil {
+		return TierValue{}, err
+	}
+	tv, ok := res[key]
+	if !ok {
+		return TierValue{}, KeyNotFoundError
+	}
+	return tv, nil
+}
+
+func (t redisTier) MGet(ctx context.Context, keys []string, opts ...Option) (map[string]TierValue, error) {
+	kvs, err := t.cli.mget(ctx, keys, t.options(opts))
+	res, ttlErr := t.withTTL(ctx, kvs)
+	if err == nil {
+		err = ttlErr
+	}
+	return res, err
+}
+
+// withTTL looks up the time the keys of kvs have left in Redis, the keys gone
+// meanwhile are left out.
+func (t redisTier) withTTL(ctx context.Context, kvs map[string]interface{}) (map[string]TierValue, error) {
+	res := make(map[string]TierValue, len(kvs))
+	if len(kvs) == 0 {
+		return res, nil
+	}
+	cmds := make(map[string]*redis.DurationCmd, len(kvs))
+	if _, err := t.cli.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for key := range kvs {
+			cmds[key] = p.PTTL(ctx, key)
+		}
+		return nil
+	}); err != nil {
+		return res, err
+	}
+	for key, cmd := range cmds {
+		switch ttl := cmd.Val(); {
+		case ttl > 0:
+			res[key] = TierValue{Value: kvs[key], TTL: ttl}
+		case ttl == -1: // no TTL
+			res[key] = TierValue{Value: kvs[key]}
+		}
+	}
+	return res, nil
+}
+
+func (t redisTier) Set(ctx context.Context, key string, value interface{}, opts ...Option) error {
+	return t.cli.set(ctx, key, value, t.options(opts))
+}
+
+func (t redisTier) MSet(ctx context.Context, keys []string, values []interface{}, opts ...Option) error {
+	return t.cli.mset(ctx, keys, values, t.options(opts))
+}
+
+func (t redisTier) Remove(ctx context.Context, keys ...string) error {
+	if len(keys) == 1 {
+		_, err := t.cli.del(ctx, keys[0])
+		return err
+	}
+	_, err := t.cli.mdel(ctx, keys)
+	return err
+}
@@ -0,0 +1,131 @@
+package mcache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChain(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.Nil(t, err)
+	defer mr.Close()
+	d, err := OpenDiskStore(t.TempDir())
+	assert.Nil(t, err)
+	defer d.Close()
+
+	var (
+		ctx   = context.TODO()
+		codec = WithUnSafeValBind(func() interface{} { return new(string) })
+		small = New[LruCache](4, WithShardCount(1))
+		large = New[LfuCache](64)
+		loads int
+	)
+	defer small.Close()
+	defer large.Close()
+	loader := WithLoaderFn(func(ctx context.Context, key string) (interface{}, error) {
+		loads++
+		if key == "broken" {
+			return nil, errors.New("broken")
+		}
+		return "loaded " + key, nil
+	})
+
+	chain := NewChain(
+		ConfigureTier(CacheTier(small), WithPropagation(PropagateInvalidate)),
+		CacheTier(large),
+		DiskTier(d, codec),
+		RedisTier(redis.NewClient(&redis.Options{Addr: mr.Addr()}), codec),
+	)
+
+	// writes go to every tier but the invalidated one.
+	assert.Nil(t, chain.Set(ctx, "a", "1", WithTTL(time.Minute)))
+	_, err = small.Get(ctx, "a")
+	assert.Equal(t, KeyNotFoundError, err)
+	val, err := large.Get(ctx, "a")
+	assert.Nil(t, err)
+	assert.Equal(t, "1", val)
+	_, _, err = d.Get("a")
+	assert.Nil(t, err)
+	assert.True(t, mr.Exists("a"))
+
+	// a hit is backfilled above.
+	large.Remove(ctx, "a")
+	val, err = chain.Get(ctx, "a")
+	assert.Nil(t, err)
+	assert.Equal(t, "1", val)
+	val, err = small.Get(ctx, "a")
+	assert.Nil(t, err)
+	assert.Equal(t, "1", val)
+	_, err = large.Get(ctx, "a")
+	assert.Nil(t, err)
+
+	// a loaded value is added to every tier, the tier caches never load.
+	val, err = chain.Get(ctx, "b", loader)
+	assert.Nil(t, err)
+	assert.Equal(t, "loaded b", val)
+	_, err = chain.Get(ctx, "b", loader)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, loads)
+	assert.True(t, mr.Exists("b"))
+	_, err = chain.Get(ctx, "broken", loader, WithDefaultVal("default"))
+	assert.Equal(t, DefaultValueSetError, err)
+
+	mr.Set("c", "\xa13")
+	res, err := chain.MGet(ctx, []string{"a", "b", "c", "d"}, WithMLoaderFn(func(ctx context.Context, keys []string) (map[string]interface{}, error) {
+		assert.Equal(t, []string{"d"}, keys)
+		return map[string]interface{}{"d": "4"}, nil
+	}))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"a": "1", "b": "loaded b", "c": "3", "d": "4"}, res)
+	_, _, err = d.Get("c")
+	assert.Nil(t, err)
+
+	// a backfilled copy never outlives its source, with or without a TTL.
+	mr.Set("f", "\xa16")
+	mr.SetTTL("f", 2*time.Second)
+	for _, opts := range [][]Option{nil, {WithTTL(time.Minute)}} {
+		small.Remove(ctx, "f")
+		large.Remove(ctx, "f")
+		_, err = d.Delete("f")
+		assert.Nil(t, err)
+
+		val, err = chain.Get(ctx, "f", opts...)
+		assert.Nil(t, err)
+		assert.Equal(t, "6", val)
+		for _, c := range []Cache{small, large} {
+			ttl, ok := c.localTTL(ctx, "f")
+			assert.True(t, ok)
+			assert.True(t, ttl > 0 && ttl <= 2*time.Second, ttl)
+		}
+		_, expireAt, err := d.Get("f")
+		assert.Nil(t, err)
+		assert.False(t, expireAt.IsZero())
+		assert.True(t, time.Until(expireAt) <= 2*time.Second)
+	}
+
+	// a tier not read nor backfilled only gets the writes.
+	skip := New[LruCache](4, WithShardCount(1))
+	defer skip.Close()
+	chain = NewChain(
+		ConfigureTier(CacheTier(skip), WithReadThrough(false), WithBackfill(false)),
+		CacheTier(large),
+	)
+	_, err = chain.Get(ctx, "a")
+	assert.Nil(t, err)
+	assert.False(t, skip.Exists(ctx, "a"))
+	assert.Nil(t, chain.Set(ctx, "e", "5"))
+	assert.True(t, skip.Exists(ctx, "e"))
+
+	assert.Nil(t, chain.Remove(ctx, "a", "e"))
+	assert.False(t, skip.Exists(ctx, "e"))
+	assert.False(t, large.Exists(ctx, "a"))
+	_, err = chain.Get(ctx, "a")
+	assert.Equal(t, KeyNotFoundError, err)
+	assert.Equal(t, KeyValueLenError, chain.MSet(ctx, []string{"a"}, nil))
+}
@@ -1,12 +1,36 @@
 package mcache
 
 import (
+	"container/heap"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
+const defaultClockResolution = time.Millisecond //粗粒度时钟的默认精度
+
+// Clock is the source of time of a cache. Everything time-driven in the cache
+// goes through it, so a FakeClock makes it deterministic.
 type Clock interface {
 	Now() time.Time
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+	// AfterFunc calls f once d has elapsed, the returned Timer has a nil C.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer mirrors time.Timer.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Ticker mirrors time.Ticker.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+	Reset(d time.Duration)
 }
 
 type realClock struct{}
@@ -19,6 +43,97 @@ func (rc realClock) Now() time.Time {
 	return time.Now()
 }
 
+func (rc realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+func (rc realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+func (rc realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return realTimer{time.AfterFunc(d, f)}
+}
+
+type realTimer struct {
+	t *time.Timer
+}
+
+func (rt realTimer) C() <-chan time.Time        { return rt.t.C }
+func (rt realTimer) Stop() bool                 { return rt.t.Stop() }
+func (rt realTimer) Reset(d time.Duration) bool { return rt.t.Reset(d) }
+
+type realTicker struct {
+	t *time.Ticker
+}
+
+func (rt realTicker) C() <-chan time.Time   { return rt.t.C }
+func (rt realTicker) Stop()                 { rt.t.Stop() }
+func (rt realTicker) Reset(d time.Duration) { rt.t.Reset(d) }
+
+// CoarseClock is a Clock whose Now is a single atomic load of a timestamp
+// refreshed every resolution by a background goroutine. It trades up to one
+// resolution of precision for a cheaper Now, Stop ends the goroutine. Its
+// timers are real ones.
+type CoarseClock interface {
+	Clock
+	Stop()
+}
+
+func NewCoarseClock(resolution time.Duration) CoarseClock {
+	if resolution <= 0 {
+		resolution = defaultClockResolution
+	}
+
+	cc := &coarseClock{
+		ticker:  time.NewTicker(resolution),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	cc.now = time.Now().UnixNano()
+	go cc.run()
+	return cc
+}
+
+type coarseClock struct {
+	realClock
+	now     int64
+	ticker  *time.Ticker
+	once    sync.Once
+	closing chan struct{}
+	done    chan struct{}
+}
+
+func (cc *coarseClock) Now() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&cc.now))
+}
+
+// Stop freezes the clock, it is safe to call Stop more than once.
+func (cc *coarseClock) Stop() {
+	cc.once.Do(func() {
+		close(cc.closing)
+	})
+	<-cc.done
+}
+
+func (cc *coarseClock) run() {
+	defer close(cc.done)
+	defer cc.ticker.Stop()
+
+	for {
+		select {
+		case <-cc.closing:
+			return
+		case now := <-cc.ticker.C:
+			atomic.StoreInt64(&cc.now, now.UnixNano())
+		}
+	}
+}
+
+// FakeClock is a Clock that only moves on Advance, which fires the timers
+// falling due in deadline order, with Now set to each deadline in turn.
+// AfterFunc callbacks run on the goroutine calling Advance, so their effects
+// are visible once it returns.
 type FakeClock interface {
 	Clock
 	Advance(d time.Duration)
@@ -31,19 +146,181 @@ func NewFakeClock() FakeClock {
 }
 
 type fakeclock struct {
-	now atomic.Value
+	now     atomic.Value
+	advance sync.Mutex // serializes Advance
+	mu      sync.Mutex // guards timers and seq
+	timers  fakeTimers
+	seq     uint64
 }
 
 func (fc *fakeclock) Now() time.Time {
 	return fc.now.Load().(time.Time)
 }
 
+func (fc *fakeclock) NewTimer(d time.Duration) Timer {
+	t := &fakeTimer{fc: fc, c: make(chan time.Time, 1), index: -1}
+	fc.schedule(t, d)
+	return t
+}
+
+func (fc *fakeclock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("mcache: non-positive interval for NewTicker")
+	}
+	t := &fakeTimer{fc: fc, c: make(chan time.Time, 1), period: d, index: -1}
+	fc.schedule(t, d)
+	return fakeTicker{t}
+}
+
+func (fc *fakeclock) AfterFunc(d time.Duration, f func()) Timer {
+	t := &fakeTimer{fc: fc, fn: f, index: -1}
+	fc.schedule(t, d)
+	return t
+}
+
+// schedule (re)arms t to fire d from now, it reports whether t was armed.
+func (fc *fakeclock) schedule(t *fakeTimer, d time.Duration) bool {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	active := fc.unschedule(t)
+	fc.seq++
+	t.seq = fc.seq
+	t.when = fc.Now().Add(d)
+	heap.Push(&fc.timers, t)
+	return active
+}
+
+// unschedule disarms t, it must be called with mu held.
+func (fc *fakeclock) unschedule(t *fakeTimer) bool {
+	if t.index < 0 {
+		return false
+	}
+	heap.Remove(&fc.timers, t.index)
+	return true
+}
+
 func (fc *fakeclock) Advance(d time.Duration) {
+	fc.advance.Lock()
+	defer fc.advance.Unlock()
+
+	target := fc.Now().Add(d)
 	for {
-		current := fc.Now()
-		newTime := current.Add(d)
-		if fc.now.CompareAndSwap(current, newTime) {
+		fc.mu.Lock()
+		if len(fc.timers) == 0 || fc.timers[0].when.After(target) {
+			fc.now.Store(target)
+			fc.mu.Unlock()
 			return
 		}
+
+		t := heap.Pop(&fc.timers).(*fakeTimer)
+		now := fc.Now()
+		if t.when.After(now) {
+			now = t.when
+			fc.now.Store(now)
+		}
+		if t.period > 0 {
+			fc.seq++
+			t.seq = fc.seq
+			t.when = t.when.Add(t.period)
+			heap.Push(&fc.timers, t)
+		}
+		fc.mu.Unlock()
+
+		t.fire(now)
+	}
+}
+
+type fakeTimer struct {
+	fc     *fakeclock
+	c      chan time.Time
+	fn     func()
+	period time.Duration
+	when   time.Time
+	seq    uint64
+	index  int
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.fc.mu.Lock()
+	defer t.fc.mu.Unlock()
+
+	return t.fc.unschedule(t)
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	return t.fc.schedule(t, d)
+}
+
+// fire runs the callback or delivers now, dropping it like a real timer does
+// when the previous value wasn't received yet.
+func (t *fakeTimer) fire(now time.Time) {
+	if t.fn != nil {
+		t.fn()
+		return
+	}
+	select {
+	case t.c <- now:
+	default:
+	}
+}
+
+type fakeTicker struct {
+	t *fakeTimer
+}
+
+func (ft fakeTicker) C() <-chan time.Time {
+	return ft.t.c
+}
+
+func (ft fakeTicker) Stop() {
+	ft.t.Stop()
+}
+
+func (ft fakeTicker) Reset(d time.Duration) {
+	if d <= 0 {
+		panic("mcache: non-positive interval for Ticker.Reset")
 	}
+	ft.t.fc.mu.Lock()
+	ft.t.period = d
+	ft.t.fc.mu.Unlock()
+	ft.t.Reset(d)
+}
+
+// fakeTimers is a min-heap of timers by deadline, then by arming order.
+type fakeTimers []*fakeTimer
+
+func (h fakeTimers) Len() int { return len(h) }
+
+func (h fakeTimers) Less(i, j int) bool {
+	if h[i].when.Equal(h[j].when) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].when.Before(h[j].when)
+}
+
+func (h fakeTimers) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *fakeTimers) Push(x interface{}) {
+	t := x.(*fakeTimer)
+	t.index = len(*h)
+	*h = append(*h, t)
+}
+
+func (h *fakeTimers) Pop() interface{} {
+	old := *h
+	n := len(old)
+	t := old[n-1]
+	old[n-1] = nil
+	t.index = -1
+	*h = old[:n-1]
+	return t
 }
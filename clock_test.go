@@ -1,6 +1,7 @@
 package mcache
 
 import (
+	"context"
 	"testing"
 	"time"
 
@@ -17,3 +18,83 @@ func TestFakeClock(t *testing.T) {
 
 	assert.Equal(t, time.Duration(0), now.Add(time.Second).Sub(fc.Now()))
 }
+
+func TestCoarseClock(t *testing.T) {
+	cc := NewCoarseClock(time.Millisecond)
+	defer cc.Stop()
+
+	start := cc.Now()
+	assert.WithinDuration(t, time.Now(), start, 10*time.Millisecond)
+
+	time.Sleep(20 * time.Millisecond)
+	assert.True(t, cc.Now().After(start))
+
+	cc.Stop()
+	cc.Stop()
+	stopped := cc.Now()
+	time.Sleep(5 * time.Millisecond)
+	assert.Equal(t, stopped, cc.Now())
+}
+
+func TestWithClock(t *testing.T) {
+	var (
+		ctx = context.TODO()
+		fc  = NewFakeClock()
+		cc  = New[LruCache](16, WithClock(fc))
+	)
+	defer cc.Close()
+
+	assert.Nil(t, cc.Set(ctx, "k", "v", WithTTL(time.Minute)))
+	fc.Advance(59 * time.Second)
+	assert.True(t, cc.Exists(ctx, "k"))
+	fc.Advance(time.Second + time.Millisecond)
+	assert.False(t, cc.Exists(ctx, "k"))
+}
+
+func TestFakeClockTimers(t *testing.T) {
+	var (
+		fc    = NewFakeClock()
+		start = fc.Now()
+		fired []string
+	)
+
+	fc.AfterFunc(3*time.Second, func() { fired = append(fired, "c") })
+	fc.AfterFunc(time.Second, func() {
+		fired = append(fired, "a")
+		assert.Equal(t, start.Add(time.Second), fc.Now())
+	})
+	b := fc.AfterFunc(2*time.Second, func() { fired = append(fired, "b") })
+	stopped := fc.AfterFunc(time.Second, func() { fired = append(fired, "stopped") })
+	assert.True(t, stopped.Stop())
+	assert.False(t, stopped.Stop())
+
+	fc.Advance(2 * time.Second)
+	assert.Equal(t, []string{"a", "b"}, fired)
+	assert.False(t, b.Reset(time.Second))
+
+	fc.Advance(time.Second)
+	assert.Equal(t, []string{"a", "b", "c", "b"}, fired)
+	assert.Equal(t, start.Add(3*time.Second), fc.Now())
+
+	timer := fc.NewTimer(time.Second)
+	select {
+	case <-timer.C():
+		t.Fatal("timer fired early")
+	default:
+	}
+	fc.Advance(time.Second)
+	assert.Equal(t, start.Add(4*time.Second), <-timer.C())
+
+	ticker := fc.NewTicker(time.Second)
+	for i := 5; i < 8; i++ {
+		fc.Advance(time.Second)
+		assert.Equal(t, start.Add(time.Duration(i)*time.Second), <-ticker.C())
+	}
+	ticker.Stop()
+	fc.Advance(time.Second)
+	select {
+	case <-ticker.C():
+		t.Fatal("ticker fired after Stop")
+	default:
+	}
+}
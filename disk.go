@@ -0,0 +1,494 @@
+package mcache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	diskHeaderSize      = 20             //记录头: 校验和, 过期时间, 键长, 值长
+	diskTombstone       = math.MaxUint32 //删除记录的值长
+	diskSegmentExt      = ".seg"
+	defaultSegmentBytes = 64 << 20 //默认的段文件大小
+)
+
+var diskCrcTable = crc32.MakeTable(crc32.Castagnoli)
+
+type DiskOption func(*diskOptions)
+
+type diskOptions struct {
+	segmentBytes int64
+	clock        Clock
+}
+
+// WithSegmentBytes sets the size past which the store starts a new segment.
+func WithSegmentBytes(n int64) DiskOption {
+	return func(o *diskOptions) {
+		o.segmentBytes = n
+	}
+}
+
+// WithDiskClock sets the clock deadlines are checked with.
+func WithDiskClock(clock Clock) DiskOption {
+	return func(o *diskOptions) {
+		o.clock = clock
+	}
+}
+
+// DiskStore is a log-structured store of encoded values on local disk. Every
+// write is appended to the active segment file and located by an in-memory
+// index, deletions append a tombstone. Records carry a checksum, a corrupted
+// record reads as missing and ends the replay of its segment on open. Once the
+// garbage left by overwritten, deleted and expired records outweighs the live
+// ones, the live records of the older segments are copied to the active one in
+// the background and the older segments are removed. Segments are synced when
+// a new one starts and on Close, a crash may lose the last writes.
+type DiskStore struct {
+	dir  string
+	opts diskOptions
+
+	mu         sync.RWMutex
+	index      map[string]diskItem
+	segs       map[uint32]*segment
+	active     *segment
+	rec        []byte // record being appended
+	compacting bool
+	closed     bool
+	wg         sync.WaitGroup
+}
+
+// diskItem locates the last record of a key.
+type diskItem struct {
+	seg      uint32
+	off      int64
+	size     uint32
+	expireAt int64 // unix nanoseconds, 0 without a TTL
+}
+
+func (it diskItem) expired(now time.Time) bool {
+	return it.expireAt != 0 && it.expireAt < now.UnixNano()
+}
+
+type segment struct {
+	id   uint32
+	f    *os.File
+	size int64
+	live int64 // bytes of the records the index points to
+}
+
+// OpenDiskStore opens the store kept in dir, creating dir if needed, and
+// replays its segments to rebuild the index.
+func OpenDiskStore(dir string, opts ...DiskOption) (*DiskStore, error) {
+	o := diskOptions{segmentBytes: defaultSegmentBytes, clock: NewRealClock()}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	names, err := filepath.Glob(filepath.Join(dir, "*"+diskSegmentExt))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	d := &DiskStore{
+		dir:   dir,
+		opts:  o,
+		index: make(map[string]diskItem),
+		segs:  make(map[uint32]*segment),
+	}
+	var next uint32
+	for _, name := range names {
+		id, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(name), diskSegmentExt), 10, 32)
+		if err != nil {
+			continue
+		}
+		if err := d.replay(uint32(id), name); err != nil {
+			d.closeFiles()
+			return nil, err
+		}
+		next = uint32(id) + 1
+	}
+	if err := d.roll(next); err != nil {
+		d.closeFiles()
+		return nil, err
+	}
+	return d, nil
+}
+
+// replay indexes the records of a segment, up to the first corrupted one.
+func (d *DiskStore) replay(id uint32, name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	seg := &segment{id: id, f: f}
+	d.segs[id] = seg
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	now := d.opts.clock.Now()
+	r := bufio.NewReader(f)
+	header := make([]byte, diskHeaderSize)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return nil
+		}
+		keyLen, valLen := binary.LittleEndian.Uint32(header[12:]), binary.LittleEndian.Uint32(header[16:])
+		bodyLen := int64(keyLen)
+		if valLen != diskTombstone {
+			bodyLen += int64(valLen)
+		}
+		if seg.size+diskHeaderSize+bodyLen > info.Size() {
+			// a torn write or a corrupted length.
+			return nil
+		}
+		rec := make([]byte, diskHeaderSize+bodyLen)
+		copy(rec, header)
+		if _, err := io.ReadFull(r, rec[diskHeaderSize:]); err != nil {
+			return nil
+		}
+		key, _, expireAt, ok := decodeRecord(rec)
+		if !ok {
+			return nil
+		}
+
+		if old, ok := d.index[key]; ok {
+			d.drop(key, old)
+		}
+		it := diskItem{seg: id, off: seg.size, size: uint32(len(rec)), expireAt: expireAt}
+		seg.size += int64(len(rec))
+		if valLen != diskTombstone && !it.expired(now) {
+			d.index[key] = it
+			seg.live += int64(it.size)
+		}
+	}
+}
+
+// roll starts the active segment id.
+func (d *DiskStore) roll(id uint32) error {
+	name := filepath.Join(d.dir, fmt.Sprintf("%010d%s", id, diskSegmentExt))
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if d.active != nil {
+		d.active.f.Sync()
+	}
+	d.active = &segment{id: id, f: f}
+	d.segs[id] = d.active
+	return nil
+}
+
+// Get returns the value of key and its deadline, zero without a TTL.
+func (d *DiskStore) Get(key string) ([]byte, time.Time, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.closed {
+		return nil, time.Time{}, DiskClosedError
+	}
+	it, ok := d.index[key]
+	if !ok || it.expired(d.opts.clock.Now()) {
+		return nil, time.Time{}, KeyNotFoundError
+	}
+	_, value, _, err := d.read(key, it)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	var expireAt time.Time
+	if it.expireAt != 0 {
+		expireAt = time.Unix(0, it.expireAt)
+	}
+	return value, expireAt, nil
+}
+
+// Set stores value under key until expireAt, zero for no TTL.
+func (d *DiskStore) Set(key string, value []byte, expireAt time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return DiskClosedError
+	}
+	var deadline int64
+	if !expireAt.IsZero() {
+		deadline = expireAt.UnixNano()
+	}
+	return d.append(key, value, deadline)
+}
+
+// Delete removes key, it reports whether key was stored.
+func (d *DiskStore) Delete(key string) (bool, error) {
+	d.mu.RLock()
+	_, ok := d.index[key]
+	d.mu.RUnlock()
+	if !ok {
+		return false, nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return false, DiskClosedError
+	}
+	it, ok := d.index[key]
+	if !ok {
+		return false, nil
+	}
+	d.drop(key, it)
+	if _, err := d.write(key, nil, 0, true); err != nil {
+		return false, err
+	}
+	return !it.expired(d.opts.clock.Now()), nil
+}
+
+// Len returns the number of keys stored, expired ones included until they are
+// compacted.
+func (d *DiskStore) Len() int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return len(d.index)
+}
+
+// Compact copies the live records of the segments older than the active one
+// to it, then removes them. Expired records are dropped.
+func (d *DiskStore) Compact() error {
+	d.mu.RLock()
+	sealed := make([]uint32, 0, len(d.segs))
+	for id := range d.segs {
+		if id != d.active.id {
+			sealed = append(sealed, id)
+		}
+	}
+	d.mu.RUnlock()
+	sort.Slice(sealed, func(i, j int) bool { return sealed[i] < sealed[j] })
+
+	for _, id := range sealed {
+		if err := d.compact(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compact moves the live records out of segment id, oldest segments first: a
+// segment must not go while an older one may hold a record its tombstones
+// hide.
+func (d *DiskStore) compact(id uint32) error {
+	d.mu.RLock()
+	var keys []string
+	for key, it := range d.index {
+		if it.seg == id {
+			keys = append(keys, key)
+		}
+	}
+	d.mu.RUnlock()
+
+	for _, key := range keys {
+		if err := d.move(key, id); err != nil {
+			return err
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return DiskClosedError
+	}
+	seg, ok := d.segs[id]
+	if !ok {
+		// compacted concurrently.
+		return nil
+	}
+	delete(d.segs, id)
+	seg.f.Close()
+	return os.Remove(seg.f.Name())
+}
+
+// move copies the record of key to the active segment if it is still in
+// segment id, one record at a time so readers are barely held up.
+func (d *DiskStore) move(key string, id uint32) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return DiskClosedError
+	}
+	it, ok := d.index[key]
+	if !ok || it.seg != id {
+		return nil
+	}
+	if it.expired(d.opts.clock.Now()) {
+		d.drop(key, it)
+		return nil
+	}
+	_, value, expireAt, err := d.read(key, it)
+	if err != nil {
+		d.drop(key, it)
+		return nil
+	}
+	return d.append(key, value, expireAt)
+}
+
+// maybeCompact starts a background compaction once the garbage of the sealed
+// segments outweighs their live records and a segment.
+func (d *DiskStore) maybeCompact() {
+	if d.compacting {
+		return
+	}
+	var size, live int64
+	for _, seg := range d.segs {
+		if seg != d.active {
+			size += seg.size
+			live += seg.live
+		}
+	}
+	if garbage := size - live; garbage < d.opts.segmentBytes || garbage < live {
+		return
+	}
+
+	d.compacting = true
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		d.Compact()
+
+		d.mu.Lock()
+		d.compacting = false
+		d.mu.Unlock()
+	}()
+}
+
+// Close waits for a compaction in progress and closes the segments.
+func (d *DiskStore) Close() error {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return nil
+	}
+	d.closed = true
+	d.mu.Unlock()
+	d.wg.Wait()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	err := d.active.f.Sync()
+	if cerr := d.closeFiles(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (d *DiskStore) closeFiles() error {
+	var err error
+	for _, seg := range d.segs {
+		if cerr := seg.f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// append writes a record of key and indexes it, with d.mu held.
+func (d *DiskStore) append(key string, value []byte, expireAt int64) error {
+	if old, ok := d.index[key]; ok {
+		d.drop(key, old)
+	}
+	off, err := d.write(key, value, expireAt, false)
+	if err != nil {
+		return err
+	}
+	it := diskItem{seg: d.active.id, off: off, size: uint32(len(d.rec)), expireAt: expireAt}
+	d.index[key] = it
+	d.active.live += int64(it.size)
+	return nil
+}
+
+// write appends a record to the active segment, starting a new one first if
+// the record would overflow it. It returns the offset of the record.
+func (d *DiskStore) write(key string, value []byte, expireAt int64, tombstone bool) (int64, error) {
+	d.rec = encodeRecord(d.rec[:0], key, value, expireAt, tombstone)
+	if d.active.size > 0 && d.active.size+int64(len(d.rec)) > d.opts.segmentBytes {
+		if err := d.roll(d.active.id + 1); err != nil {
+			return 0, err
+		}
+		d.maybeCompact()
+	}
+	off := d.active.size
+	if _, err := d.active.f.Write(d.rec); err != nil {
+		return 0, err
+	}
+	d.active.size += int64(len(d.rec))
+	return off, nil
+}
+
+func (d *DiskStore) drop(key string, it diskItem) {
+	delete(d.index, key)
+	if seg, ok := d.segs[it.seg]; ok {
+		seg.live -= int64(it.size)
+	}
+}
+
+// read loads and checks the record it locates.
+func (d *DiskStore) read(key string, it diskItem) (string, []byte, int64, error) {
+	rec := make([]byte, it.size)
+	if _, err := d.segs[it.seg].f.ReadAt(rec, it.off); err != nil {
+		return "", nil, 0, err
+	}
+	k, value, expireAt, ok := decodeRecord(rec)
+	if !ok || k != key {
+		return "", nil, 0, DiskChecksumError
+	}
+	return k, value, expireAt, nil
+}
+
+// encodeRecord appends to b a record laid out as: crc32 of the rest, deadline
+// in unix nanoseconds, key length, value length or diskTombstone, key, value.
+func encodeRecord(b []byte, key string, value []byte, expireAt int64, tombstone bool) []byte {
+	var header [diskHeaderSize]byte
+	valLen := uint32(len(value))
+	if tombstone {
+		valLen = diskTombstone
+	}
+	binary.LittleEndian.PutUint64(header[4:], uint64(expireAt))
+	binary.LittleEndian.PutUint32(header[12:], uint32(len(key)))
+	binary.LittleEndian.PutUint32(header[16:], valLen)
+
+	b = append(b, header[:]...)
+	b = append(b, key...)
+	b = append(b, value...)
+	binary.LittleEndian.PutUint32(b, crc32.Checksum(b[4:], diskCrcTable))
+	return b
+}
+
+func decodeRecord(rec []byte) (key string, value []byte, expireAt int64, ok bool) {
+	if len(rec) < diskHeaderSize || binary.LittleEndian.Uint32(rec) != crc32.Checksum(rec[4:], diskCrcTable) {
+		return "", nil, 0, false
+	}
+	keyLen := int(binary.LittleEndian.Uint32(rec[12:]))
+	if diskHeaderSize+keyLen > len(rec) {
+		return "", nil, 0, false
+	}
+	key = string(rec[diskHeaderSize : diskHeaderSize+keyLen])
+	value = rec[diskHeaderSize+keyLen:]
+	return key, value, int64(binary.LittleEndian.Uint64(rec[4:])), true
+}
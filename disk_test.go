@@ -0,0 +1,163 @@
+package mcache
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiskStore(t *testing.T) {
+	var (
+		dir = t.TempDir()
+		fc  = NewFakeClock()
+	)
+	d, err := OpenDiskStore(dir, WithDiskClock(fc))
+	assert.Nil(t, err)
+
+	assert.Nil(t, d.Set("a", []byte("1"), time.Time{}))
+	assert.Nil(t, d.Set("b", []byte("2"), fc.Now().Add(time.Second)))
+	assert.Nil(t, d.Set("a", []byte("3"), time.Time{}))
+	assert.Nil(t, d.Set("c", []byte("4"), time.Time{}))
+	ok, err := d.Delete("c")
+	assert.True(t, ok)
+	assert.Nil(t, err)
+
+	val, expireAt, err := d.Get("a")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("3"), val)
+	assert.True(t, expireAt.IsZero())
+	val, expireAt, err = d.Get("b")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("2"), val)
+	assert.Equal(t, fc.Now().Add(time.Second).UnixNano(), expireAt.UnixNano())
+	_, _, err = d.Get("c")
+	assert.Equal(t, KeyNotFoundError, err)
+
+	fc.Advance(2 * time.Second)
+	_, _, err = d.Get("b")
+	assert.Equal(t, KeyNotFoundError, err)
+	assert.Nil(t, d.Close())
+	_, _, err = d.Get("a")
+	assert.Equal(t, DiskClosedError, err)
+
+	// the index is rebuilt from the segments, expired entries are left out.
+	d, err = OpenDiskStore(dir, WithDiskClock(fc))
+	assert.Nil(t, err)
+	defer d.Close()
+	assert.Equal(t, 1, d.Len())
+	val, _, err = d.Get("a")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("3"), val)
+}
+
+func TestDiskStoreCorruption(t *testing.T) {
+	dir := t.TempDir()
+	d, err := OpenDiskStore(dir)
+	assert.Nil(t, err)
+	assert.Nil(t, d.Set("a", []byte("1"), time.Time{}))
+	assert.Nil(t, d.Set("b", []byte("2"), time.Time{}))
+	assert.Nil(t, d.Close())
+
+	// flip the last byte, the value of b.
+	names, _ := filepath.Glob(filepath.Join(dir, "*"+diskSegmentExt))
+	assert.Len(t, names, 1)
+	data, err := os.ReadFile(names[0])
+	assert.Nil(t, err)
+	data[len(data)-1] ^= 0xff
+	assert.Nil(t, os.WriteFile(names[0], data, 0o644))
+
+	d, err = OpenDiskStore(dir)
+	assert.Nil(t, err)
+	defer d.Close()
+	_, _, err = d.Get("a")
+	assert.Nil(t, err)
+	_, _, err = d.Get("b")
+	assert.Equal(t, KeyNotFoundError, err)
+	assert.Nil(t, d.Set("b", []byte("2"), time.Time{}))
+}
+
+func TestDiskStoreCompaction(t *testing.T) {
+	var (
+		dir   = t.TempDir()
+		fc    = NewFakeClock()
+		value = make([]byte, 100)
+	)
+	d, err := OpenDiskStore(dir, WithSegmentBytes(1<<10), WithDiskClock(fc))
+	assert.Nil(t, err)
+	defer d.Close()
+
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, d.Set(fmt.Sprint(i%5), value, time.Time{}))
+	}
+	assert.Nil(t, d.Set("ttl", value, fc.Now().Add(time.Second)))
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, d.Set(fmt.Sprint("new", i), value, time.Time{}))
+	}
+	fc.Advance(2 * time.Second)
+	assert.Nil(t, d.Compact())
+
+	names, _ := filepath.Glob(filepath.Join(dir, "*"+diskSegmentExt))
+	var size int64
+	for _, name := range names {
+		info, err := os.Stat(name)
+		assert.Nil(t, err)
+		size += info.Size()
+	}
+	assert.Less(t, size, int64(20*(diskHeaderSize+110)))
+	assert.Equal(t, 15, d.Len())
+	for i := 0; i < 5; i++ {
+		_, _, err := d.Get(fmt.Sprint(i))
+		assert.Nil(t, err)
+	}
+}
+
+func TestDiskTier(t *testing.T) {
+	var (
+		ctx   = context.TODO()
+		codec = WithUnSafeValBind(func() interface{} { return new(string) })
+	)
+	d, err := OpenDiskStore(t.TempDir())
+	assert.Nil(t, err)
+	defer d.Close()
+
+	var evicted int
+	onEvict := func(key string, value interface{}, reason EvictReason) { evicted++ }
+	c := New[LruCache](4, WithShardCount(1), WithDiskTier(d), WithOnEvict(onEvict), codec)
+	defer c.Close()
+
+	for i := 0; i < 8; i++ {
+		assert.Nil(t, c.Set(ctx, fmt.Sprint(i), fmt.Sprint("v", i), WithTTL(time.Minute)))
+	}
+	assert.Equal(t, 4, evicted)
+	assert.Equal(t, 4, d.Len())
+
+	// a disk hit is promoted back to memory, demoting the coldest entry.
+	val, err := c.Get(ctx, "0")
+	assert.Nil(t, err)
+	assert.Equal(t, "v0", val)
+	_, err = c.debugLocalGet(ctx, "0")
+	assert.Nil(t, err)
+	_, _, err = d.Get("0")
+	assert.Equal(t, KeyNotFoundError, err)
+	_, _, err = d.Get("4")
+	assert.Nil(t, err)
+
+	res, err := c.MGet(ctx, []string{"1", "2", "missing"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"1": "v1", "2": "v2"}, res)
+
+	// writes drop the stale disk copy.
+	assert.Nil(t, c.Set(ctx, "3", "new"))
+	assert.True(t, c.Remove(ctx, "4"))
+	_, err = c.Get(ctx, "4")
+	assert.Equal(t, KeyNotFoundError, err)
+
+	s := c.Stats()
+	assert.Equal(t, uint64(3), s.DiskHits)
+	assert.Equal(t, uint64(2), s.Misses)
+}
@@ -0,0 +1,159 @@
+package mcache
+
+import (
+	"container/heap"
+	"time"
+)
+
+// expiration is embedded by every policy item, it carries the key and deadline
+// of the item and its position in the shard's expiryIndex.
+type expiration struct {
+	key      string
+	expireAt time.Time
+	expires  bool
+	// loadTime is how long the value took to load, for XFetch.
+	loadTime time.Duration
+
+	// index is the position in a heapIndex.
+	index int
+	// slot, prev and next link the item into a timingWheel.
+	slot       *wheelSlot
+	prev, next *expiration
+}
+
+func (e *expiration) IsExpired(clock Clock) bool {
+	return e.expireAt.Before(clock.Now())
+}
+
+// deadline returns the deadline of e for an Entry, zero without a TTL.
+func (e *expiration) deadline() time.Time {
+	if !e.expires {
+		return time.Time{}
+	}
+	return e.expireAt
+}
+
+// expiryIndex orders the items of a shard by deadline, it lets policies
+// reclaim expired items before evicting live ones. Items without a TTL are left
+// out of the index unless indexAll is set.
+type expiryIndex interface {
+	// set updates the deadline of e and its position in the index, expires
+	// is false for items without a TTL.
+	set(e *expiration, expireAt time.Time, expires bool)
+	remove(e *expiration)
+	// expired returns an item whose deadline passed, if any.
+	expired(now time.Time) *expiration
+	// peek returns the item closest to its deadline.
+	peek() *expiration
+	Len() int
+}
+
+// newExpiryIndex returns a timingWheel ticking every o.SweepInterval when a
+// sweep is configured, and a heapIndex otherwise.
+func newExpiryIndex(clock Clock, capacity int, indexAll bool, o policyOptions) expiryIndex {
+	if o.SweepInterval > 0 {
+		return newTimingWheel(clock.Now(), o.SweepInterval, indexAll)
+	}
+	return newHeapIndex(capacity, indexAll)
+}
+
+func deadline(now time.Time, ttl time.Duration) time.Time {
+	if ttl > 0 {
+		return now.Add(ttl)
+	}
+	return now.Add(defaultExpiredAt)
+}
+
+// entryDeadline is the inverse of expiration.deadline.
+func entryDeadline(now time.Time, e Entry) (time.Time, bool) {
+	if e.ExpireAt.IsZero() {
+		return now.Add(defaultExpiredAt), false
+	}
+	return e.ExpireAt, true
+}
+
+// heapIndex is a min-heap of items ordered by deadline.
+type heapIndex struct {
+	h        expiryHeap
+	indexAll bool
+}
+
+func newHeapIndex(capacity int, indexAll bool) *heapIndex {
+	return &heapIndex{
+		h:        make(expiryHeap, 0, capacity),
+		indexAll: indexAll,
+	}
+}
+
+func (x *heapIndex) set(e *expiration, expireAt time.Time, expires bool) {
+	e.expireAt = expireAt
+	e.expires = expires
+
+	indexed := e.index >= 0 && e.index < len(x.h) && x.h[e.index] == e
+	switch {
+	case !expires && !x.indexAll:
+		if indexed {
+			heap.Remove(&x.h, e.index)
+		}
+		e.index = -1
+	case indexed:
+		heap.Fix(&x.h, e.index)
+	default:
+		heap.Push(&x.h, e)
+	}
+}
+
+func (x *heapIndex) remove(e *expiration) {
+	if e.index >= 0 && e.index < len(x.h) && x.h[e.index] == e {
+		heap.Remove(&x.h, e.index)
+	}
+	e.index = -1
+}
+
+func (x *heapIndex) expired(now time.Time) *expiration {
+	if e := x.peek(); e != nil && e.expireAt.Before(now) {
+		return e
+	}
+	return nil
+}
+
+func (x *heapIndex) peek() *expiration {
+	if len(x.h) == 0 {
+		return nil
+	}
+	return x.h[0]
+}
+
+func (x *heapIndex) Len() int {
+	return len(x.h)
+}
+
+type expiryHeap []*expiration
+
+func (h expiryHeap) Len() int { return len(h) }
+
+func (h expiryHeap) Less(i, j int) bool {
+	return h[i].expireAt.Before(h[j].expireAt)
+}
+
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *expiryHeap) Push(x interface{}) {
+	e := x.(*expiration)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	e.index = -1
+	*h = old[:n-1]
+	return e
+}
@@ -0,0 +1,125 @@
+package mcache
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"time"
+)
+
+const (
+	defaultHandoffTimeout = 10 * time.Second //默认的交接时限
+	maxHandoffTimeout     = time.Minute      //接收方可要求的最长交接时限
+)
+
+// ServeHandoff listens on the Unix socket path and hands the live local
+// entries, hottest first, to the first cache calling ReceiveHandoff on it,
+// then returns. It is meant for the outgoing process of a restart on the same
+// host. A file left at path, e.g. by a crashed process, is replaced. The
+// handoff runs under the deadline and byte cap asked by the receiving cache,
+// the deadline capped to a minute from now and the byte cap by WithMaxBytes.
+// Canceling ctx stops waiting for a receiver or the handoff.
+func (c *cacheHandler[T, P]) ServeHandoff(ctx context.Context, path string, opts ...Option) error {
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			ln.Close()
+		case <-stop:
+		}
+	}()
+
+	conn, err := ln.Accept()
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	defer conn.Close()
+
+	// the receiver asks for its byte cap and the time left before its
+	// deadline.
+	conn.SetReadDeadline(time.Now().Add(defaultHandoffTimeout))
+	r := bufio.NewReader(conn)
+	maxBytes, err := binary.ReadUvarint(r)
+	if err != nil {
+		return err
+	}
+	timeout, err := binary.ReadUvarint(r)
+	if err != nil {
+		return err
+	}
+
+	if o.MaxBytes > 0 && (maxBytes == 0 || uint64(o.MaxBytes) < maxBytes) {
+		maxBytes = uint64(o.MaxBytes)
+	}
+	if timeout > uint64(maxHandoffTimeout) {
+		timeout = uint64(maxHandoffTimeout)
+	}
+	deadline := time.Now().Add(time.Duration(timeout))
+	conn.SetDeadline(deadline)
+	hctx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+
+	return c.snapshot(hctx, conn, int64(maxBytes))
+}
+
+// ReceiveHandoff connects to the Unix socket path served by ServeHandoff and
+// adds the entries it hands off to the local shards, as Restore does. The
+// handoff stops at the deadline of ctx, or after 10s without one, and at most
+// a minute in, and WithMaxBytes caps the bytes transferred. The entries
+// received before the deadline are kept.
+func (c *cacheHandler[T, P]) ReceiveHandoff(ctx context.Context, path string, opts ...Option) error {
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(defaultHandoffTimeout)
+	}
+	ctx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", path)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(deadline)
+
+	var buf [2 * binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], uint64(o.MaxBytes))
+	n += binary.PutUvarint(buf[n:], uint64(time.Until(deadline)))
+	if _, err := conn.Write(buf[:n]); err != nil {
+		return err
+	}
+
+	err = c.Restore(ctx, conn)
+	if errors.Is(err, SnapshotFormatError) && !time.Now().Before(deadline) {
+		// the deadline cut the last entry.
+		return context.DeadlineExceeded
+	}
+	return err
+}
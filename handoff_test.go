@@ -0,0 +1,72 @@
+package mcache
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandoff(t *testing.T) {
+	dir, err := os.MkdirTemp("", "mcache")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	var (
+		ctx   = context.TODO()
+		path  = filepath.Join(dir, "handoff.sock")
+		codec = WithUnSafeValBind(func() interface{} { return new(string) })
+		src   = New[LruCache](256, WithShardCount(4), WithHasher(XXHashString), codec)
+	)
+	defer src.Close()
+
+	for i := 0; i < 128; i++ {
+		assert.Nil(t, src.Set(ctx, fmt.Sprint(i), fmt.Sprint(i), WithTTL(time.Minute)))
+	}
+	src.Get(ctx, "8")
+
+	serve := func(opts ...Option) chan error {
+		done := make(chan error, 1)
+		go func() { done <- src.ServeHandoff(ctx, path, opts...) }()
+		for {
+			if _, err := os.Stat(path); err == nil {
+				return done
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	done := serve()
+	dst := New[LruCache](256, WithShardCount(2), codec)
+	defer dst.Close()
+	assert.Nil(t, dst.ReceiveHandoff(ctx, path))
+	assert.Nil(t, <-done)
+	assert.Equal(t, 128, dst.Stats().Size)
+	val, err := dst.Get(ctx, "42")
+	assert.Nil(t, err)
+	assert.Equal(t, "42", val)
+
+	// a small cap hands off the hottest entries of the shards in turn, "8"
+	// was hit in the first shard. XXHashString keeps the shards stable.
+	done = serve(WithMaxBytes(1 << 20))
+	capped := New[LruCache](256, WithHasher(XXHashString), codec)
+	defer capped.Close()
+	assert.Nil(t, capped.ReceiveHandoff(ctx, path, WithMaxBytes(64)))
+	assert.Nil(t, <-done)
+	var kept []string
+	for i := 0; i < 128; i++ {
+		if capped.Exists(ctx, fmt.Sprint(i)) {
+			kept = append(kept, fmt.Sprint(i))
+		}
+	}
+	assert.Equal(t, []string{"8", "126", "127"}, kept)
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.Equal(t, context.Canceled, src.ServeHandoff(canceled, path))
+	assert.NotNil(t, dst.ReceiveHandoff(ctx, filepath.Join(dir, "missing.sock")))
+}
@@ -1,6 +1,7 @@
 package mcache
 
 import (
+	"encoding/binary"
 	"unsafe"
 
 	"github.com/cespare/xxhash/v2"
@@ -57,10 +58,41 @@ func MemHashString(str string) uint64 {
 	return uint64(memhash(ss.str, 0, uintptr(ss.len)))
 }
 
+// XXHashString hashes str with xxhash, it is stable across processes.
 func XXHashString(str string) uint64 {
 	return xxhash.Sum64String(str)
 }
 
+// XXHashStringSeed returns a hash like XXHashString whose output depends on
+// seed too, it is stable across processes sharing the seed.
+func XXHashStringSeed(seed uint64) Hasher {
+	var prefix [8]byte
+	binary.LittleEndian.PutUint64(prefix[:], seed)
+	return func(str string) uint64 {
+		var d xxhash.Digest
+		d.Reset()
+		d.Write(prefix[:])
+		d.WriteString(str)
+		return d.Sum64()
+	}
+}
+
+// FnvHashString hashes str with FNV-1a, it is stable across processes. Unlike
+// Fnv32 every byte reaches the low bits used to pick a shard.
+func FnvHashString(str string) uint64 {
+	return uint64(fnv32a(stringToBytes(&str)))
+}
+
+func fnv32a(key []byte) uint32 {
+	hash := uint32(2166136261)
+	const prime32 = uint32(16777619)
+	for _, b := range key {
+		hash ^= uint32(b)
+		hash *= prime32
+	}
+	return hash
+}
+
 func Fnv32(key []byte) uint32 {
 	hash := uint32(2166136261)
 	const prime32 = uint32(16777619)
@@ -0,0 +1,256 @@
+package mcache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultInvalidationBackoff    = 100 * time.Millisecond //删除失败后首次重试前的默认等待
+	defaultInvalidationMaxBackoff = 30 * time.Second       //默认的重试等待上限
+	defaultInvalidationPending    = 1 << 14                //默认的最大待重试键数
+)
+
+// InvalidationRetry configures the retries of the Redis deletes of
+// Invalidate, a zero field takes its default.
+type InvalidationRetry struct {
+	// Backoff is the wait before retrying failed deletes, 100ms by default.
+	// It doubles with every failure in a row, up to MaxBackoff.
+	Backoff time.Duration
+	// MaxBackoff is 30s by default.
+	MaxBackoff time.Duration
+	// MaxPending bounds the keys waiting for a retry, 16384 by default. The
+	// deletes of the keys over are dropped.
+	MaxPending int
+}
+
+func (ir *InvalidationRetry) withDefaults() {
+	if ir.Backoff <= 0 {
+		ir.Backoff = defaultInvalidationBackoff
+	}
+	if ir.MaxBackoff <= 0 {
+		ir.MaxBackoff = defaultInvalidationMaxBackoff
+	}
+	if ir.MaxPending <= 0 {
+		ir.MaxPending = defaultInvalidationPending
+	}
+}
+
+// InvalidationStats describes the background work of Invalidate.
+type InvalidationStats struct {
+	// Pending is the number of keys waiting for a retry of their delete,
+	// Delayed the number of second deletes scheduled.
+	Pending int
+	Delayed int
+	// Retried counts the failed deletes queued for a retry, Dropped the ones
+	// given up on.
+	Retried uint64
+	Dropped uint64
+}
+
+// Invalidate removes keys from Redis, the disk tier and the local shards,
+// whatever the WriteMode, e.g. after the database they are loaded from was
+// updated. With WithDoubleDelete they are removed a second time after a
+// delay, in case a concurrent reader put back a stale value. A failed Redis
+// delete is returned and retried in the background until it succeeds.
+func (c *cacheHandler[T, P]) Invalidate(ctx context.Context, keys []string, opts ...Option) error {
+	ctx, sp := startSpan(ctx, c.tracer, "invalidate", TierCache, len(keys))
+	err := c.invalidate(ctx, keys, opts...)
+	sp.end(writeOutcome(err), err)
+	return err
+}
+
+func (c *cacheHandler[T, P]) invalidate(ctx context.Context, keys []string, opts ...Option) error {
+	o := c.getOption(opts...)
+	defer c.putOpt(o)
+
+	err := c.invalidateKeys(ctx, keys)
+	if o.DoubleDelete > 0 {
+		keys := append([]string(nil), keys...) // the caller may reuse keys
+		c.invalidator.delay(o.DoubleDelete, func() {
+			c.invalidateKeys(context.Background(), keys)
+		})
+	}
+	return err
+}
+
+// invalidateKeys deletes keys from Redis first, so the local shards can't be
+// filled again with the Redis values, then from the local tiers.
+func (c *cacheHandler[T, P]) invalidateKeys(ctx context.Context, keys []string) error {
+	c.writeBehind.cancel(c.remoteKeys(keys))
+
+	var err error
+	if c.redisCli.Client != nil {
+		rkeys := c.remoteKeys(keys)
+		if len(rkeys) == 1 {
+			_, err = c.redisCli.del(ctx, rkeys[0])
+		} else {
+			_, err = c.redisCli.mdel(ctx, rkeys)
+		}
+		if err != nil {
+			c.invalidator.retry(rkeys)
+		}
+	}
+
+	for _, key := range keys {
+		c.diskRemove(ctx, key)
+		c.localRemove(ctx, key, EvictExplicit)
+	}
+	return err
+}
+
+// invalidator retries the failed Redis deletes of Invalidate and runs its
+// delayed second deletes.
+type invalidator struct {
+	cfg   InvalidationRetry
+	cli   RedisCli
+	clock Clock
+
+	mu      sync.Mutex
+	pending map[string]struct{}
+	timer   Timer // armed while keys are pending
+	backoff time.Duration
+	delayed map[Timer]func()
+	closed  bool
+
+	retried uint64
+	dropped uint64
+}
+
+func newInvalidator(cfg InvalidationRetry, cli RedisCli, clock Clock) *invalidator {
+	cfg.withDefaults()
+	return &invalidator{
+		cfg:     cfg,
+		cli:     cli,
+		clock:   clock,
+		pending: make(map[string]struct{}),
+		delayed: make(map[Timer]func()),
+	}
+}
+
+// delay calls fn after d, or on close if it comes first. Once closed fn is
+// called right away.
+func (i *invalidator) delay(d time.Duration, fn func()) {
+	i.mu.Lock()
+	if i.closed {
+		i.mu.Unlock()
+		fn()
+		return
+	}
+	var t Timer
+	t = i.clock.AfterFunc(d, func() {
+		i.mu.Lock()
+		_, ok := i.delayed[t]
+		delete(i.delayed, t)
+		i.mu.Unlock()
+		if ok {
+			fn()
+		}
+	})
+	i.delayed[t] = fn
+	i.mu.Unlock()
+}
+
+// retry queues the deletes of keys.
+func (i *invalidator) retry(keys []string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	for _, key := range keys {
+		if _, ok := i.pending[key]; !ok && len(i.pending) >= i.cfg.MaxPending {
+			atomic.AddUint64(&i.dropped, 1)
+			continue
+		}
+		i.pending[key] = struct{}{}
+		atomic.AddUint64(&i.retried, 1)
+	}
+	i.arm()
+}
+
+// arm schedules the next retry, i.mu is held.
+func (i *invalidator) arm() {
+	if i.closed || i.timer != nil || len(i.pending) == 0 {
+		return
+	}
+	if i.backoff == 0 {
+		i.backoff = i.cfg.Backoff
+	}
+	i.timer = i.clock.AfterFunc(i.backoff, func() {
+		i.flush(context.Background())
+	})
+}
+
+// flush retries the pending deletes, the keys failing again are kept for the
+// next retry with a longer backoff.
+func (i *invalidator) flush(ctx context.Context) error {
+	i.mu.Lock()
+	keys := make([]string, 0, len(i.pending))
+	for key := range i.pending {
+		keys = append(keys, key)
+	}
+	i.pending = make(map[string]struct{}, len(keys))
+	i.mu.Unlock()
+
+	var err error
+	if len(keys) > 0 {
+		_, err = i.cli.mdel(ctx, keys)
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.timer = nil
+	if err == nil {
+		i.backoff = 0
+	} else {
+		for _, key := range keys {
+			i.pending[key] = struct{}{}
+		}
+		if i.backoff *= 2; i.backoff > i.cfg.MaxBackoff {
+			i.backoff = i.cfg.MaxBackoff
+		}
+	}
+	i.arm()
+	return err
+}
+
+// close runs the second deletes scheduled and retries the pending deletes a
+// last time.
+func (i *invalidator) close(ctx context.Context) error {
+	i.mu.Lock()
+	if i.closed {
+		i.mu.Unlock()
+		return nil
+	}
+	i.closed = true
+	if i.timer != nil {
+		i.timer.Stop()
+	}
+	delayed := make([]func(), 0, len(i.delayed))
+	for t, fn := range i.delayed {
+		t.Stop()
+		delayed = append(delayed, fn)
+	}
+	i.delayed = nil
+	i.mu.Unlock()
+
+	for _, fn := range delayed {
+		fn()
+	}
+	if i.cli.Client == nil {
+		return nil
+	}
+	return i.flush(ctx)
+}
+
+func (i *invalidator) stats() InvalidationStats {
+	i.mu.Lock()
+	s := InvalidationStats{Pending: len(i.pending), Delayed: len(i.delayed)}
+	i.mu.Unlock()
+
+	s.Retried = atomic.LoadUint64(&i.retried)
+	s.Dropped = atomic.LoadUint64(&i.dropped)
+	return s
+}
@@ -0,0 +1,71 @@
+package mcache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInvalidate(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.Nil(t, err)
+	defer mr.Close()
+
+	var (
+		ctx    = context.TODO()
+		fc     = NewFakeClock()
+		client = redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+		codec  = WithUnSafeValBind(func() interface{} { return new(string) })
+		c      = New[LruCache](64, WithRedisClient(client), codec, WithClock(fc))
+	)
+	defer c.Close()
+
+	assert.Nil(t, c.MSet(ctx, []string{"a", "b"}, []interface{}{"1", "2"}))
+	assert.Nil(t, c.Invalidate(ctx, []string{"a", "b"}))
+	assert.False(t, mr.Exists("a"))
+	assert.False(t, c.Exists(ctx, "b"))
+
+	// the second delete drops the value a reader put back meanwhile.
+	assert.Nil(t, c.Invalidate(ctx, []string{"c"}, WithDoubleDelete(time.Second)))
+	assert.Nil(t, c.Set(ctx, "c", "stale"))
+	assert.Equal(t, 1, c.Stats().Invalidation.Delayed)
+	fc.Advance(time.Second)
+	assert.False(t, mr.Exists("c"))
+	assert.False(t, c.Exists(ctx, "c"))
+	assert.Equal(t, 0, c.Stats().Invalidation.Delayed)
+
+	// the second delete keeps the keys passed, whatever the caller does with
+	// the slice after.
+	keys := []string{"x"}
+	assert.Nil(t, c.Invalidate(ctx, keys, WithDoubleDelete(time.Second)))
+	keys[0] = "y"
+	assert.Nil(t, c.MSet(ctx, []string{"x", "y"}, []interface{}{"stale", "kept"}))
+	fc.Advance(time.Second)
+	assert.False(t, c.Exists(ctx, "x"))
+	assert.True(t, c.Exists(ctx, "y"))
+
+	// a failed delete is retried until Redis is back.
+	assert.Nil(t, c.Set(ctx, "d", "4"))
+	mr.Close()
+	assert.NotNil(t, c.Invalidate(ctx, []string{"d"}))
+	assert.False(t, c.Exists(ctx, "d"))
+	fc.Advance(defaultInvalidationBackoff)
+	assert.Equal(t, 1, c.Stats().Invalidation.Pending)
+	assert.Nil(t, mr.Restart())
+	assert.True(t, mr.Exists("d"))
+	fc.Advance(2 * defaultInvalidationBackoff)
+	assert.False(t, mr.Exists("d"))
+	s := c.Stats().Invalidation
+	assert.Equal(t, 0, s.Pending)
+	assert.Equal(t, uint64(1), s.Retried)
+
+	// Close runs the second deletes still scheduled.
+	assert.Nil(t, c.Invalidate(ctx, []string{"e"}, WithDoubleDelete(time.Hour)))
+	assert.Nil(t, c.Set(ctx, "e", "stale"))
+	assert.Nil(t, c.Close())
+	assert.False(t, mr.Exists("e"))
+}
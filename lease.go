@@ -0,0 +1,197 @@
+package mcache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	defaultLeaseTTL  = 3 * time.Second       //默认的租约时长
+	defaultLeasePoll = 20 * time.Millisecond //等待租约时默认的轮询间隔
+	leaseKeySuffix   = ":mcache:lease"       //租约键的后缀
+	staleKeySuffix   = ":mcache:stale"       //过期副本键的后缀
+	leaseTokenLen    = 16                    //租约令牌的字节数
+)
+
+// releaseScript deletes a lease only if it is still held with the token, so a
+// holder whose lease expired can't release the lease of another process.
+var releaseScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0`)
+
+// Lease configures the Redis leases of WithLease, a zero field takes its
+// default.
+type Lease struct {
+	// TTL bounds how long a lease is held, in case its holder dies while
+	// loading, 3s by default. It should exceed the duration of a load.
+	TTL time.Duration
+	// Wait is how long a miss waits for the lease holder to store the value
+	// before loading it anyway, TTL by default.
+	Wait time.Duration
+	// Poll is the interval Redis is polled at while waiting, 20ms by default.
+	Poll time.Duration
+	// StaleTTL keeps a copy of the values loaded under a lease, or written to
+	// Redis, for that long after they expire, it is served instead of
+	// waiting while another process holds the lease. 0 disables the stale
+	// copies, as does a value set without TTL.
+	StaleTTL time.Duration
+}
+
+func (l *Lease) withDefaults() {
+	if l.TTL <= 0 {
+		l.TTL = defaultLeaseTTL
+	}
+	if l.Wait <= 0 {
+		l.Wait = l.TTL
+	}
+	if l.Poll <= 0 {
+		l.Poll = defaultLeasePoll
+	}
+}
+
+// LeaseStats describes the loads of Get under a lease of WithLease.
+type LeaseStats struct {
+	// Acquired counts the leases taken to run the loader, Waited the misses
+	// served the value stored by another holder and Stale the ones served a
+	// stale copy. TimedOut counts the misses that gave up waiting and ran the
+	// loader without a lease.
+	Acquired uint64
+	Waited   uint64
+	Stale    uint64
+	TimedOut uint64
+}
+
+type leaseStats struct {
+	acquired uint64
+	waited   uint64
+	stale    uint64
+	timedOut uint64
+}
+
+func (s *leaseStats) snapshot() LeaseStats {
+	return LeaseStats{
+		Acquired: atomic.LoadUint64(&s.acquired),
+		Waited:   atomic.LoadUint64(&s.waited),
+		Stale:    atomic.LoadUint64(&s.stale),
+		TimedOut: atomic.LoadUint64(&s.timedOut),
+	}
+}
+
+func leaseToken() (string, error) {
+	var buf [leaseTokenLen]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf[:]), nil
+}
+
+// leaseLoad runs load for key k once it holds the lease of k, so a single
+// process loads a key missing from Redis. Meanwhile the other processes poll
+// Redis for the value, or serve its stale copy with StaleValueError, and run
+// load themselves once they waited too long. Every poll missing the value
+// races for the lease again, so a holder whose load failed hands the lease to
+// a single waiter rather than letting them all time out. If Redis fails load
+// runs right away.
+func (c cache) leaseLoad(ctx context.Context, k string, o options, load fillFunc) (interface{}, time.Duration, error) {
+	token, err := leaseToken()
+	if err != nil {
+		return load(ctx, k)
+	}
+
+	var (
+		cfg      = c.lease
+		key      = k + leaseKeySuffix
+		deadline = c.clock.Now().Add(cfg.Wait)
+	)
+	for {
+		ok, err := c.redisCli.acquire(ctx, key, token, cfg.TTL)
+		if err != nil {
+			return load(ctx, k)
+		}
+		if ok {
+			atomic.AddUint64(&c.stats.lease.acquired, 1)
+			defer c.redisCli.release(context.Background(), key, token)
+			v, took, err := load(ctx, k)
+			if err == nil && cfg.StaleTTL > 0 && o.TTL > 0 {
+				so := o
+				so.TTL, so.TTLJitter = o.ttlOf(k)+cfg.StaleTTL, 0
+				c.redisCli.set(ctx, k+staleKeySuffix, v, so)
+			}
+			return v, took, err
+		}
+
+		if cfg.StaleTTL > 0 {
+			if v, err := c.redisCli.get(ctx, k+staleKeySuffix, o); err == nil {
+				atomic.AddUint64(&c.stats.lease.stale, 1)
+				c.stats.remoteHit(1)
+				return v, 0, StaleValueError
+			}
+		}
+		if !c.clock.Now().Before(deadline) {
+			atomic.AddUint64(&c.stats.lease.timedOut, 1)
+			return load(ctx, k)
+		}
+
+		t := c.clock.NewTimer(cfg.Poll)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, 0, ctx.Err()
+		case <-t.C():
+		}
+		if v, err := c.redisCli.get(ctx, k, o); err == nil {
+			atomic.AddUint64(&c.stats.lease.waited, 1)
+			c.stats.remoteHit(1)
+			return v, 0, nil
+		}
+		// still missing, the lease is either held or free again after a
+		// failed load.
+	}
+}
+
+// remoteKeys returns keys and the keys of their stale copies, if any, to
+// delete from Redis.
+func (c cache) remoteKeys(keys []string) []string {
+	if c.staleTTL() <= 0 {
+		return keys
+	}
+	all := make([]string, 0, 2*len(keys))
+	all = append(all, keys...)
+	for _, key := range keys {
+		all = append(all, key+staleKeySuffix)
+	}
+	return all
+}
+
+// staleTTL is how long the stale copies outlive the values written to Redis,
+// 0 if the lease keeps none.
+func (c cache) staleTTL() time.Duration {
+	if c.lease == nil {
+		return 0
+	}
+	return c.lease.StaleTTL
+}
+
+// writeStale refreshes the stale copies of keys after a write to Redis, so a
+// miss waiting for a lease isn't served the values the write replaced. It
+// is best effort: a copy failing to refresh only expires StaleTTL late.
+func (c cache) writeStale(ctx context.Context, keys []string, values []interface{}, o options) {
+	if c.staleTTL() <= 0 || o.TTL <= 0 {
+		return
+	}
+	skeys := c.remoteKeys(keys)[len(keys):]
+	so := o
+	so.TTL, so.TTLJitter = o.TTL+c.staleTTL(), 0
+	if len(skeys) == 1 {
+		c.redisCli.set(ctx, skeys[0], values[0], so)
+	} else {
+		c.redisCli.mset(ctx, skeys, values, so)
+	}
+}
@@ -0,0 +1,182 @@
+package mcache
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLease(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.Nil(t, err)
+	defer mr.Close()
+
+	var (
+		ctx    = context.TODO()
+		loads  int32
+		client = redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+		codec  = WithUnSafeValBind(func() interface{} { return new(string) })
+		loader = WithLoaderFn(func(ctx context.Context, key string) (interface{}, error) {
+			atomic.AddInt32(&loads, 1)
+			return "loaded", nil
+		})
+		lease = Lease{TTL: time.Second, Wait: 200 * time.Millisecond, Poll: time.Millisecond, StaleTTL: time.Minute}
+		c1    = New[LruCache](64, WithRedisClient(client), codec, loader, WithTTL(time.Minute), WithLease(lease))
+		c2    = New[LruCache](64, WithRedisClient(client), codec, loader, WithTTL(time.Minute), WithLease(lease))
+	)
+	defer c1.Close()
+	defer c2.Close()
+
+	// the holder loads, stores a stale copy and releases the lease.
+	val, err := c1.Get(ctx, "a")
+	assert.Nil(t, err)
+	assert.Equal(t, "loaded", val)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&loads))
+	assert.False(t, mr.Exists("a"+leaseKeySuffix))
+	assert.True(t, mr.Exists("a"+staleKeySuffix))
+
+	// while another process holds the lease the stale copy is served, and
+	// not kept locally.
+	assert.Nil(t, mr.Set("a"+leaseKeySuffix, "other"))
+	mr.Del("a")
+	val, err = c2.Get(ctx, "a")
+	assert.Equal(t, StaleValueError, err)
+	assert.Equal(t, "loaded", val)
+	assert.False(t, c2.Exists(ctx, "a"))
+
+	// without a stale copy the value stored by the holder is waited for.
+	assert.Nil(t, mr.Set("b"+leaseKeySuffix, "other"))
+	done := make(chan interface{})
+	go func() {
+		val, _ := c2.Get(ctx, "b")
+		done <- val
+	}()
+	time.Sleep(20 * time.Millisecond)
+	assert.Nil(t, c1.Set(ctx, "b", "stored"))
+	assert.Equal(t, "stored", <-done)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&loads))
+
+	// a waiter loads anyway once it waited too long.
+	assert.Nil(t, mr.Set("c"+leaseKeySuffix, "other"))
+	val, err = c2.Get(ctx, "c")
+	assert.Nil(t, err)
+	assert.Equal(t, "loaded", val)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&loads))
+
+	// a lease is only released with its token.
+	assert.Nil(t, c1.(*cacheHandler[LruCache, *LruCache]).redisCli.release(ctx, "c"+leaseKeySuffix, "mine"))
+	assert.True(t, mr.Exists("c"+leaseKeySuffix))
+
+	// Remove deletes the stale copies too.
+	assert.True(t, c1.Remove(ctx, "a"))
+	assert.False(t, mr.Exists("a"+staleKeySuffix))
+
+	s1, s2 := c1.Stats().Lease, c2.Stats().Lease
+	assert.Equal(t, uint64(1), s1.Acquired)
+	assert.Equal(t, uint64(1), s2.Stale)
+	assert.Equal(t, uint64(1), s2.Waited)
+	assert.Equal(t, uint64(1), s2.TimedOut)
+}
+
+func TestLeaseStaleAfterSet(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.Nil(t, err)
+	defer mr.Close()
+
+	var (
+		ctx    = context.TODO()
+		client = redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+		codec  = WithUnSafeValBind(func() interface{} { return new(string) })
+		loader = WithLoaderFn(func(ctx context.Context, key string) (interface{}, error) {
+			return "loaded", nil
+		})
+		lease = Lease{Poll: time.Millisecond, StaleTTL: time.Minute}
+		c1    = New[LruCache](64, WithRedisClient(client), codec, loader, WithTTL(time.Minute), WithLease(lease))
+		c2    = New[LruCache](64, WithRedisClient(client), codec, loader, WithTTL(time.Minute), WithLease(lease))
+		wb    = New[LruCache](64, WithRedisClient(client), codec, WithTTL(time.Minute), WithLease(lease), WithWriteBehind(WriteBehind{}))
+	)
+	defer c1.Close()
+	defer c2.Close()
+
+	// a Set refreshes the stale copy of the loaded value it replaces.
+	_, err = c1.Get(ctx, "a")
+	assert.Nil(t, err)
+	assert.Nil(t, c1.Set(ctx, "a", "set"))
+	assert.Equal(t, 2*time.Minute, mr.TTL("a"+staleKeySuffix))
+
+	assert.Nil(t, mr.Set("a"+leaseKeySuffix, "other"))
+	mr.Del("a")
+	val, err := c2.Get(ctx, "a")
+	assert.Equal(t, StaleValueError, err)
+	assert.Equal(t, "set", val)
+
+	// so do the writes queued behind, unless removed before the flush.
+	assert.Nil(t, wb.Set(ctx, "b", "queued"))
+	assert.Nil(t, wb.Set(ctx, "c", "queued"))
+	wb.Remove(ctx, "c")
+	assert.Nil(t, wb.Close())
+	assert.False(t, mr.Exists("c"+staleKeySuffix))
+	assert.Equal(t, 2*time.Minute, mr.TTL("b"+staleKeySuffix))
+	assert.Nil(t, mr.Set("b"+leaseKeySuffix, "other"))
+	mr.Del("b")
+	val, err = c2.Get(ctx, "b")
+	assert.Equal(t, StaleValueError, err)
+	assert.Equal(t, "queued", val)
+}
+
+func TestLeaseFailingHolder(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.Nil(t, err)
+	defer mr.Close()
+
+	var (
+		ctx       = context.TODO()
+		loads     int32
+		inflight  int32
+		overlaps  int32
+		codec     = WithUnSafeValBind(func() interface{} { return new(string) })
+		lease     = Lease{TTL: 5 * time.Second, Poll: time.Millisecond}
+		failure   = errors.New("failure")
+		caches    = make([]Cache, 5)
+		results   = make(chan error, len(caches))
+		startedAt = time.Now()
+	)
+	loader := WithLoaderFn(func(ctx context.Context, key string) (interface{}, error) {
+		if atomic.AddInt32(&inflight, 1) > 1 {
+			atomic.AddInt32(&overlaps, 1)
+		}
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt32(&inflight, -1)
+		atomic.AddInt32(&loads, 1)
+		return nil, failure
+	})
+	for i := range caches {
+		client := redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+		caches[i] = New[LruCache](64, WithRedisClient(client), codec, loader, WithLease(lease))
+		defer caches[i].Close()
+	}
+
+	// every failed holder hands the lease over to a waiter, which loads in
+	// turn instead of waiting for Wait to run out.
+	for _, c := range caches {
+		go func(c Cache) {
+			_, err := c.Get(ctx, "a")
+			results <- err
+		}(c)
+	}
+	for range caches {
+		assert.Equal(t, failure, <-results)
+	}
+	assert.Less(t, time.Since(startedAt), lease.TTL)
+	assert.Equal(t, int32(len(caches)), atomic.LoadInt32(&loads))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&overlaps))
+	for _, c := range caches {
+		assert.Equal(t, uint64(0), c.Stats().Lease.TimedOut)
+	}
+}
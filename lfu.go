@@ -3,16 +3,43 @@ package mcache
 import (
 	"container/list"
 	"context"
+	"math/rand"
 	"sync"
 	"time"
 )
 
+const lfuLogCounterMax = 255
+
+// LfuAging controls how LfuCache forgets popularity, so keys that were hot in
+// the past don't pin themselves in the cache forever. The zero value disables
+// aging and frequencies only ever grow.
+type LfuAging struct {
+	// HalveEvery halves every frequency after that many accesses, counting
+	// both hits and sets.
+	HalveEvery int
+	// LogFactor turns frequencies into logarithmic Morris counters capped at
+	// 255, as in Redis LFU: a hit only increments a counter c with
+	// probability 1/(c*LogFactor+1).
+	LogFactor int
+	// DecayPeriod decrements every frequency by one for each period elapsed.
+	DecayPeriod time.Duration
+}
+
 type LfuCache struct {
 	clock    Clock
-	items    map[string]lfuItem
+	items    map[string]*lfuItem
 	freqList *list.List
 	cap      int
-	sync.Mutex
+	expiry   expiryIndex
+	reads    readBuffer[lfuItem]
+	counters shardCounters
+	listener evictListener
+	weigher
+	sync.RWMutex
+
+	aging    LfuAging
+	accesses int
+	decayAt  time.Time
 }
 
 type freqEntry struct {
@@ -20,131 +47,423 @@ type freqEntry struct {
 	items map[string]*lfuItem
 }
 
-func (c *LfuCache) Init(clock Clock, capacity int) {
+func (c *LfuCache) Init(clock Clock, capacity int, opts ...PolicyOption) {
 	c.clock = clock
-	c.items = make(map[string]lfuItem, capacity)
+	c.items = make(map[string]*lfuItem, capacity)
 	c.freqList = list.New()
 	c.cap = capacity
 	c.freqList.PushFront(&freqEntry{
 		freq:  0,
 		items: make(map[string]*lfuItem, 8),
 	})
+
+	o := newPolicyOptions(opts...)
+	c.expiry = newExpiryIndex(clock, capacity, false, o)
+	c.weigher.init(o)
+	c.listener.init(o)
+	c.aging = o.LfuAging
+	c.accesses = 0
+	c.decayAt = clock.Now().Add(c.aging.DecayPeriod)
+	c.reads.init()
+	c.counters = shardCounters{}
 }
 
 func (c *LfuCache) Set(ctx context.Context, key string, val interface{}, ttl time.Duration) error {
 	c.Lock()
-	defer c.Unlock()
+	defer c.listener.unlock(c)
+	c.drainReads(ctx)
 
 	value := deref(val)
-	item, ok := c.items[key]
-	if ttl > 0 {
-		item.expireAt = c.clock.Now().Add(ttl)
-	} else {
-		item.expireAt = c.clock.Now().Add(defaultExpiredAt)
+	cost := c.cost(key, value)
+	if c.tooLarge(cost) {
+		return ValueTooLargeError
 	}
+	c.decay()
+	c.count()
 
+	item, ok := c.items[key]
 	if ok {
+		reason := evictReason(c.clock, &item.expiration, EvictReplaced)
+		c.counters.evicted(reason)
+		c.listener.add(&item.expiration, item.value, reason)
+		c.used += cost - item.cost
 		item.value = value
+		item.cost = cost
+		c.shrink(item)
 	} else {
-		c.evict(ctx, 1)
-		item.key = key
-		item.value = value
-		item.freqElement = nil
+		c.makeRoom(ctx, cost)
+		item = &lfuItem{
+			expiration: expiration{key: key},
+			value:      value,
+			cost:       cost,
+		}
 
 		el := c.freqList.Front()
 		fe := el.Value.(*freqEntry)
-		fe.items[key] = &item
+		fe.items[key] = item
 
 		item.freqElement = el
 		c.items[key] = item
+		c.used += cost
 	}
 
+	c.expiry.set(&item.expiration, deadline(c.clock.Now(), ttl), ttl > 0)
+
 	return nil
 }
 
+// Get only takes the read lock, the hit is counted later. Expired entries are
+// left for eviction or the sweeper to reclaim.
 func (c *LfuCache) Get(ctx context.Context, key string) (interface{}, error) {
-	c.Lock()
-	defer c.Unlock()
-
+	c.RLock()
 	item, ok := c.items[key]
-	if ok {
-		if !item.IsExpired(c.clock) {
-			c.increment(&item)
-			return item.value, nil
-		}
-		c.removeItem(&item)
+	if !ok {
+		c.RUnlock()
+		c.counters.miss()
+		return nil, KeyNotFoundError
+	}
+	if item.IsExpired(c.clock) {
+		c.RUnlock()
+		c.counters.miss()
 		return nil, KeyExpiredError
 	}
-	return nil, KeyNotFoundError
+	c.counters.hit()
+	val := item.value
+	full := c.reads.offer(item)
+	c.RUnlock()
+
+	if full && c.TryLock() {
+		c.drainReads(ctx)
+		c.Unlock()
+	}
+	return val, nil
 }
 
 func (c *LfuCache) Exists(ctx context.Context, key string) bool {
-	c.Lock()
-	defer c.Unlock()
+	c.RLock()
+	defer c.RUnlock()
 
 	item, ok := c.items[key]
-	if !ok {
-		return false
-	}
+	return ok && !item.IsExpired(c.clock)
+}
 
-	if item.IsExpired(c.clock) {
-		c.removeItem(&item)
-		return false
-	}
+// Deadline returns the deadline of the live entry of key, zero without a TTL,
+// and the load time recorded for it by SetLoadTime.
+func (c *LfuCache) Deadline(ctx context.Context, key string) (time.Time, time.Duration, bool) {
+	c.RLock()
+	defer c.RUnlock()
 
-	return true
+	item, ok := c.items[key]
+	if !ok || item.IsExpired(c.clock) {
+		return time.Time{}, 0, false
+	}
+	return item.deadline(), item.loadTime, true
 }
 
-func (c *LfuCache) Remove(ctx context.Context, key string) bool {
+// SetLoadTime records how long the value of the entry of key took to load.
+func (c *LfuCache) SetLoadTime(ctx context.Context, key string, d time.Duration) {
 	c.Lock()
 	defer c.Unlock()
 
+	if item, ok := c.items[key]; ok {
+		item.loadTime = d
+	}
+}
+
+// Remove reports whether a live entry was removed, expired ones are removed
+// as EvictExpired whatever reason says.
+func (c *LfuCache) Remove(ctx context.Context, key string, reason EvictReason) bool {
+	c.Lock()
+	defer c.listener.unlock(c)
+
 	item, ok := c.items[key]
 	if ok {
-		c.removeItem(&item)
-		return true
+		c.removeItem(item, reason)
+		return !item.IsExpired(c.clock)
 	}
 	return false
 }
 
 func (c *LfuCache) Evict(ctx context.Context, count int) {
 	c.Lock()
-	defer c.Unlock()
+	defer c.listener.unlock(c)
+	c.drainReads(ctx)
 
 	c.evict(ctx, count)
 }
 
+func (c *LfuCache) Sweep(ctx context.Context) int {
+	c.Lock()
+	defer c.listener.unlock(c)
+
+	n := 0
+	now := c.clock.Now()
+	for e := c.expiry.expired(now); e != nil; e = c.expiry.expired(now) {
+		c.removeItem(c.items[e.key], EvictExpired)
+		n++
+	}
+	return n
+}
+
+func (c *LfuCache) Resize(ctx context.Context, capacity int, maxBytes int64) {
+	c.Lock()
+	defer c.listener.unlock(c)
+	c.drainReads(ctx)
+
+	c.cap = capacity
+	if c.weigher.resize(maxBytes) {
+		for _, item := range c.items {
+			item.cost = c.cost(item.key, item.value)
+			c.used += item.cost
+		}
+	}
+
+	for len(c.items) > c.cap || c.overflow(0) {
+		item := c.victim(nil)
+		if item == nil {
+			return
+		}
+		c.removeItem(item, EvictCapacity)
+	}
+}
+
+func (c *LfuCache) Range(ctx context.Context, fn func(Entry) bool) {
+	c.Lock()
+	defer c.Unlock()
+	c.drainReads(ctx)
+
+	for el := c.freqList.Back(); el != nil; el = el.Prev() {
+		entry := el.Value.(*freqEntry)
+		for _, item := range entry.items {
+			if item.IsExpired(c.clock) {
+				continue
+			}
+			if !fn(Entry{Key: item.key, Value: item.value, ExpireAt: item.deadline(), LoadTime: item.loadTime, Freq: uint64(entry.freq)}) {
+				return
+			}
+		}
+	}
+}
+
+func (c *LfuCache) Insert(ctx context.Context, e Entry) error {
+	c.Lock()
+	defer c.listener.unlock(c)
+	c.drainReads(ctx)
+
+	if _, ok := c.items[e.Key]; ok {
+		return nil
+	}
+
+	now := c.clock.Now()
+	expireAt, expires := entryDeadline(now, e)
+	if expireAt.Before(now) {
+		return nil
+	}
+
+	cost := c.cost(e.Key, e.Value)
+	if c.tooLarge(cost) {
+		return ValueTooLargeError
+	}
+
+	if !c.fits(cost) {
+		return ShardFullError
+	}
+	freq := uint(e.Freq)
+	if c.aging.LogFactor > 0 && freq > lfuLogCounterMax {
+		freq = lfuLogCounterMax
+	}
+	item := &lfuItem{
+		expiration: expiration{key: e.Key, loadTime: e.LoadTime},
+		value:      e.Value,
+		cost:       cost,
+	}
+	item.freqElement = c.bucket(freq)
+	item.freqElement.Value.(*freqEntry).items[e.Key] = item
+	c.items[e.Key] = item
+	c.expiry.set(&item.expiration, expireAt, expires)
+	c.used += cost
+	return nil
+}
+
 func (c *LfuCache) evict(ctx context.Context, count int) {
-	if len(c.items) < c.cap {
+	if len(c.items) < c.cap && !c.overflow(0) {
 		return
 	}
 
-	entry := c.freqList.Front()
-	for i := 0; i < count; {
-		if entry == nil {
+	for i := 0; i < count; i++ {
+		item := c.victim(nil)
+		if item == nil {
 			return
-		} else {
-			for _, item := range entry.Value.(*freqEntry).items {
-				if i >= count {
-					return
-				}
-				c.removeItem(item)
-				i++
+		}
+		c.removeItem(item, EvictCapacity)
+	}
+}
+
+// makeRoom evicts the least frequently used entries until an entry weighing
+// cost fits.
+func (c *LfuCache) makeRoom(ctx context.Context, cost int64) {
+	for len(c.items) >= c.cap || c.overflow(cost) {
+		item := c.victim(nil)
+		if item == nil {
+			return
+		}
+		c.removeItem(item, EvictCapacity)
+	}
+}
+
+// fits removes expired entries until an entry weighing cost fits, and reports
+// whether it does without evicting a live one.
+func (c *LfuCache) fits(cost int64) bool {
+	for len(c.items) >= c.cap || c.overflow(cost) {
+		e := c.expiry.expired(c.clock.Now())
+		if e == nil {
+			return false
+		}
+		c.removeItem(c.items[e.key], EvictExpired)
+	}
+	return true
+}
+
+// shrink evicts other entries after keep grew past the byte budget.
+func (c *LfuCache) shrink(keep *lfuItem) {
+	for c.overflow(0) {
+		item := c.victim(keep)
+		if item == nil {
+			return
+		}
+		c.removeItem(item, EvictCapacity)
+	}
+}
+
+// victim returns an expired entry if there is one, an entry of the lowest
+// populated frequency otherwise, skipping skip.
+func (c *LfuCache) victim(skip *lfuItem) *lfuItem {
+	if e := c.expiry.expired(c.clock.Now()); e != nil {
+		if item := c.items[e.key]; item != skip {
+			return item
+		}
+	}
+
+	for el := c.freqList.Front(); el != nil; el = el.Next() {
+		for _, item := range el.Value.(*freqEntry).items {
+			if item != skip {
+				return item
 			}
-			entry = entry.Next()
 		}
 	}
+	return nil
 }
 
-func (c *LfuCache) removeItem(item *lfuItem) {
+func (c *LfuCache) removeItem(item *lfuItem, reason EvictReason) {
+	reason = evictReason(c.clock, &item.expiration, reason)
+	c.counters.evicted(reason)
+	c.listener.add(&item.expiration, item.value, reason)
 	entry := item.freqElement.Value.(*freqEntry)
 	delete(c.items, item.key)
-	entry.items[item.key] = nil
 	delete(entry.items, item.key)
 	if isRemovableFreqEntry(entry) {
 		c.freqList.Remove(item.freqElement)
 	}
-	item = nil
+	c.expiry.remove(&item.expiration)
+	c.used -= item.cost
+}
+
+// hit records an access to item, applying the configured aging.
+func (c *LfuCache) Stats() ShardStats {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.counters.stats(len(c.items))
+}
+
+// drainReads replays the buffered hits, skipping entries removed since.
+func (c *LfuCache) drainReads(ctx context.Context) {
+	c.reads.drain(func(item *lfuItem) {
+		if c.items[item.key] == item {
+			c.hit(item)
+		}
+	})
+}
+
+func (c *LfuCache) hit(item *lfuItem) {
+	c.decay()
+	defer c.count()
+
+	freq := item.freqElement.Value.(*freqEntry).freq
+	if c.aging.LogFactor > 0 {
+		if freq >= lfuLogCounterMax {
+			return
+		}
+		if rand.Float64()*float64(freq*uint(c.aging.LogFactor)+1) >= 1 {
+			return
+		}
+	}
+	c.increment(item)
+}
+
+// count halves every frequency once HalveEvery accesses were made.
+func (c *LfuCache) count() {
+	if c.aging.HalveEvery <= 0 {
+		return
+	}
+
+	c.accesses++
+	if c.accesses >= c.aging.HalveEvery {
+		c.accesses = 0
+		c.age(func(freq uint) uint { return freq / 2 })
+	}
+}
+
+// decay subtracts the decay periods elapsed since the last call from every
+// frequency.
+func (c *LfuCache) decay() {
+	if c.aging.DecayPeriod <= 0 {
+		return
+	}
+
+	now := c.clock.Now()
+	if now.Before(c.decayAt) {
+		return
+	}
+
+	periods := uint(now.Sub(c.decayAt)/c.aging.DecayPeriod) + 1
+	c.decayAt = c.decayAt.Add(time.Duration(periods) * c.aging.DecayPeriod)
+	c.age(func(freq uint) uint {
+		if freq < periods {
+			return 0
+		}
+		return freq - periods
+	})
+}
+
+// age rebuilds the frequency list with every frequency mapped through fn,
+// which must be monotonic so the list stays sorted.
+func (c *LfuCache) age(fn func(uint) uint) {
+	old := c.freqList
+	c.freqList = list.New()
+	c.freqList.PushFront(&freqEntry{
+		freq:  0,
+		items: make(map[string]*lfuItem, 8),
+	})
+
+	for el := old.Front(); el != nil; el = el.Next() {
+		entry := el.Value.(*freqEntry)
+		freq := fn(entry.freq)
+
+		back := c.freqList.Back()
+		if back.Value.(*freqEntry).freq != freq {
+			back = c.freqList.PushBack(&freqEntry{
+				freq:  freq,
+				items: make(map[string]*lfuItem, len(entry.items)),
+			})
+		}
+
+		items := back.Value.(*freqEntry).items
+		for key, item := range entry.items {
+			items[key] = item
+			item.freqElement = back
+		}
+	}
 }
 
 func (c *LfuCache) increment(item *lfuItem) {
@@ -178,17 +497,34 @@ func (c *LfuCache) increment(item *lfuItem) {
 	item.freqElement = nextFreqElement
 }
 
+// bucket returns the element of the frequency list for freq, inserting it
+// when missing.
+func (c *LfuCache) bucket(freq uint) *list.Element {
+	el := c.freqList.Front()
+	for ; el != nil; el = el.Next() {
+		switch f := el.Value.(*freqEntry).freq; {
+		case f == freq:
+			return el
+		case f > freq:
+			return c.freqList.InsertBefore(&freqEntry{
+				freq:  freq,
+				items: make(map[string]*lfuItem),
+			}, el)
+		}
+	}
+	return c.freqList.PushBack(&freqEntry{
+		freq:  freq,
+		items: make(map[string]*lfuItem),
+	})
+}
+
 func isRemovableFreqEntry(entry *freqEntry) bool {
 	return entry.freq != 0 && len(entry.items) == 0
 }
 
 type lfuItem struct {
-	key         string
+	expiration
 	value       interface{}
+	cost        int64
 	freqElement *list.Element
-	expireAt    time.Time
-}
-
-func (it *lfuItem) IsExpired(clock Clock) bool {
-	return it.expireAt.Before(clock.Now())
 }
@@ -0,0 +1,44 @@
+package mcache
+
+import "sync"
+
+// OnEvictFunc is called with every entry leaving a local shard and why.
+type OnEvictFunc func(key string, value interface{}, reason EvictReason)
+
+// evictListener queues the entries a policy drops under its lock, they are
+// reported once the lock is released so the callback can't stall the shard.
+type evictListener struct {
+	onEvict func(Entry, EvictReason)
+	pending []evicted
+}
+
+type evicted struct {
+	entry  Entry
+	reason EvictReason
+}
+
+func (l *evictListener) init(o policyOptions) {
+	l.onEvict = o.OnEvict
+	l.pending = nil
+}
+
+func (l *evictListener) add(e *expiration, value interface{}, reason EvictReason) {
+	if l.onEvict != nil && reason < evictReasons {
+		l.pending = append(l.pending, evicted{
+			entry:  Entry{Key: e.key, Value: value, ExpireAt: e.deadline()},
+			reason: reason,
+		})
+	}
+}
+
+// unlock releases mu, the write lock of the policy, then reports the queued
+// entries.
+func (l *evictListener) unlock(mu sync.Locker) {
+	pending := l.pending
+	l.pending = nil
+	mu.Unlock()
+
+	for _, e := range pending {
+		l.onEvict(e.entry, e.reason)
+	}
+}
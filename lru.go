@@ -12,125 +12,308 @@ type LruCache struct {
 	items     map[string]*list.Element
 	evictList *list.List
 	cap       int
-	sync.Mutex
+	expiry    expiryIndex
+	reads     readBuffer[list.Element]
+	counters  shardCounters
+	listener  evictListener
+	weigher
+	sync.RWMutex
 }
 
-func (c *LruCache) Init(clock Clock, capacity int) {
+func (c *LruCache) Init(clock Clock, capacity int, opts ...PolicyOption) {
 	c.clock = clock
 	c.items = make(map[string]*list.Element, capacity+1)
 	c.evictList = list.New()
 	c.cap = capacity
+	o := newPolicyOptions(opts...)
+	c.expiry = newExpiryIndex(clock, capacity, false, o)
+	c.weigher.init(o)
+	c.listener.init(o)
+	c.reads.init()
+	c.counters = shardCounters{}
 }
 
 func (c *LruCache) Set(ctx context.Context, key string, val interface{}, ttl time.Duration) error {
 	c.Lock()
-	defer c.Unlock()
+	defer c.listener.unlock(c)
+	c.drainReads(ctx)
 
 	value := deref(val)
+	cost := c.cost(key, value)
+	if c.tooLarge(cost) {
+		return ValueTooLargeError
+	}
+
 	it, ok := c.items[key]
 	if ok {
-		item := it.Value.(*lfuItem)
+		item := it.Value.(*lruItem)
+		reason := evictReason(c.clock, &item.expiration, EvictReplaced)
+		c.counters.evicted(reason)
+		c.listener.add(&item.expiration, item.value, reason)
+		c.used += cost - item.cost
 		item.value = value
-		if ttl > 0 {
-			item.expireAt = c.clock.Now().Add(ttl)
-		} else {
-			item.expireAt = c.clock.Now().Add(defaultExpiredAt)
-		}
+		item.cost = cost
+		c.expiry.set(&item.expiration, deadline(c.clock.Now(), ttl), ttl > 0)
 		c.evictList.MoveToFront(it)
-	} else {
-		c.evict(ctx, 1)
-		item := lruItem{
-			key:   key,
-			value: value,
+		for c.overflow(0) {
+			if ent := c.victim(); ent != nil && ent != it {
+				c.removeElement(ent, EvictCapacity)
+			} else {
+				break
+			}
 		}
-		if ttl > 0 {
-			item.expireAt = c.clock.Now().Add(ttl)
-		} else {
-			item.expireAt = c.clock.Now().Add(defaultExpiredAt)
+	} else {
+		c.makeRoom(ctx, cost)
+		item := &lruItem{
+			expiration: expiration{key: key},
+			value:      value,
+			cost:       cost,
 		}
-		c.items[key] = c.evictList.PushFront(&item)
+		c.expiry.set(&item.expiration, deadline(c.clock.Now(), ttl), ttl > 0)
+		c.items[key] = c.evictList.PushFront(item)
+		c.used += cost
 	}
 
 	return nil
 }
 
+// Get only takes the read lock, the hit is replayed into the recency order
+// later. Expired entries are left for eviction or the sweeper to reclaim.
 func (c *LruCache) Get(ctx context.Context, key string) (interface{}, error) {
-	c.Lock()
-	defer c.Unlock()
+	c.RLock()
+	ent, ok := c.items[key]
+	if !ok || ent.Value.(*lruItem).IsExpired(c.clock) {
+		c.RUnlock()
+		c.counters.miss()
+		return nil, KeyNotFoundError
+	}
+	c.counters.hit()
+	val := ent.Value.(*lruItem).value
+	full := c.reads.offer(ent)
+	c.RUnlock()
 
-	item, ok := c.items[key]
-	if ok {
-		it := item.Value.(*lruItem)
-		if !it.IsExpired(c.clock) {
-			c.evictList.MoveToFront(item)
-			return it.value, nil
-		}
-		c.removeElement(item)
+	if full && c.TryLock() {
+		c.drainReads(ctx)
+		c.Unlock()
 	}
-	return nil, KeyNotFoundError
+	return val, nil
 }
 
 func (c *LruCache) Exists(ctx context.Context, key string) bool {
-	c.Lock()
-	defer c.Unlock()
+	c.RLock()
+	defer c.RUnlock()
 
-	item, ok := c.items[key]
-	if !ok {
-		return false
-	}
+	ent, ok := c.items[key]
+	return ok && !ent.Value.(*lruItem).IsExpired(c.clock)
+}
 
-	if item.Value.(*lruItem).IsExpired(c.clock) {
-		c.removeElement(item)
-		return false
+// Deadline returns the deadline of the live entry of key, zero without a TTL,
+// and the load time recorded for it by SetLoadTime.
+func (c *LruCache) Deadline(ctx context.Context, key string) (time.Time, time.Duration, bool) {
+	c.RLock()
+	defer c.RUnlock()
+
+	ent, ok := c.items[key]
+	if !ok || ent.Value.(*lruItem).IsExpired(c.clock) {
+		return time.Time{}, 0, false
 	}
-	return true
+	item := ent.Value.(*lruItem)
+	return item.deadline(), item.loadTime, true
 }
 
-func (c *LruCache) Remove(ctx context.Context, key string) bool {
+// SetLoadTime records how long the value of the entry of key took to load.
+func (c *LruCache) SetLoadTime(ctx context.Context, key string, d time.Duration) {
 	c.Lock()
 	defer c.Unlock()
 
 	if ent, ok := c.items[key]; ok {
-		c.removeElement(ent)
-		return true
+		ent.Value.(*lruItem).loadTime = d
+	}
+}
+
+// Remove reports whether a live entry was removed, expired ones are removed
+// as EvictExpired whatever reason says.
+func (c *LruCache) Remove(ctx context.Context, key string, reason EvictReason) bool {
+	c.Lock()
+	defer c.listener.unlock(c)
+
+	if ent, ok := c.items[key]; ok {
+		c.removeElement(ent, reason)
+		return !ent.Value.(*lruItem).IsExpired(c.clock)
 	}
 	return false
 }
 
 func (c *LruCache) Evict(ctx context.Context, count int) {
 	c.Lock()
-	defer c.Unlock()
+	defer c.listener.unlock(c)
+	c.drainReads(ctx)
 
 	c.evict(ctx, count)
 }
 
+func (c *LruCache) Sweep(ctx context.Context) int {
+	c.Lock()
+	defer c.listener.unlock(c)
+
+	n := 0
+	now := c.clock.Now()
+	for e := c.expiry.expired(now); e != nil; e = c.expiry.expired(now) {
+		c.removeElement(c.items[e.key], EvictExpired)
+		n++
+	}
+	return n
+}
+
+func (c *LruCache) Resize(ctx context.Context, capacity int, maxBytes int64) {
+	c.Lock()
+	defer c.listener.unlock(c)
+	c.drainReads(ctx)
+
+	c.cap = capacity
+	if c.weigher.resize(maxBytes) {
+		for _, ent := range c.items {
+			item := ent.Value.(*lruItem)
+			item.cost = c.cost(item.key, item.value)
+			c.used += item.cost
+		}
+	}
+
+	for c.evictList.Len() > c.cap || c.overflow(0) {
+		ent := c.victim()
+		if ent == nil {
+			return
+		}
+		c.removeElement(ent, EvictCapacity)
+	}
+}
+
+func (c *LruCache) Range(ctx context.Context, fn func(Entry) bool) {
+	c.Lock()
+	defer c.Unlock()
+	c.drainReads(ctx)
+
+	for ent := c.evictList.Front(); ent != nil; ent = ent.Next() {
+		item := ent.Value.(*lruItem)
+		if item.IsExpired(c.clock) {
+			continue
+		}
+		if !fn(Entry{Key: item.key, Value: item.value, ExpireAt: item.deadline(), LoadTime: item.loadTime}) {
+			return
+		}
+	}
+}
+
+func (c *LruCache) Insert(ctx context.Context, e Entry) error {
+	c.Lock()
+	defer c.listener.unlock(c)
+	c.drainReads(ctx)
+
+	if _, ok := c.items[e.Key]; ok {
+		return nil
+	}
+
+	now := c.clock.Now()
+	expireAt, expires := entryDeadline(now, e)
+	if expireAt.Before(now) {
+		return nil
+	}
+
+	cost := c.cost(e.Key, e.Value)
+	if c.tooLarge(cost) {
+		return ValueTooLargeError
+	}
+
+	if !c.fits(cost) {
+		return ShardFullError
+	}
+	item := &lruItem{
+		expiration: expiration{key: e.Key, loadTime: e.LoadTime},
+		value:      e.Value,
+		cost:       cost,
+	}
+	c.expiry.set(&item.expiration, expireAt, expires)
+	c.items[e.Key] = c.evictList.PushBack(item)
+	c.used += cost
+	return nil
+}
+
 func (c *LruCache) evict(ctx context.Context, count int) {
-	if c.evictList.Len() < c.cap {
+	if c.evictList.Len() < c.cap && !c.overflow(0) {
 		return
 	}
 
 	for i := 0; i < count; i++ {
-		ent := c.evictList.Back()
+		ent := c.victim()
 		if ent == nil {
 			return
 		} else {
-			c.removeElement(ent)
+			c.removeElement(ent, EvictCapacity)
 		}
 	}
 }
 
-func (c *LruCache) removeElement(e *list.Element) {
+func (c *LruCache) Stats() ShardStats {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.counters.stats(len(c.items))
+}
+
+// drainReads replays the buffered hits, skipping entries removed since.
+func (c *LruCache) drainReads(ctx context.Context) {
+	c.reads.drain(func(ent *list.Element) {
+		if c.items[ent.Value.(*lruItem).key] == ent {
+			c.evictList.MoveToFront(ent)
+		}
+	})
+}
+
+// makeRoom evicts until an entry weighing cost fits.
+func (c *LruCache) makeRoom(ctx context.Context, cost int64) {
+	for c.evictList.Len() >= c.cap || c.overflow(cost) {
+		ent := c.victim()
+		if ent == nil {
+			return
+		}
+		c.removeElement(ent, EvictCapacity)
+	}
+}
+
+// fits removes expired entries until an entry weighing cost fits, and reports
+// whether it does without evicting a live one.
+func (c *LruCache) fits(cost int64) bool {
+	for c.evictList.Len() >= c.cap || c.overflow(cost) {
+		e := c.expiry.expired(c.clock.Now())
+		if e == nil {
+			return false
+		}
+		c.removeElement(c.items[e.key], EvictExpired)
+	}
+	return true
+}
+
+// victim returns an expired entry if there is one, the tail otherwise.
+func (c *LruCache) victim() *list.Element {
+	if e := c.expiry.expired(c.clock.Now()); e != nil {
+		return c.items[e.key]
+	}
+	return c.evictList.Back()
+}
+
+func (c *LruCache) removeElement(e *list.Element, reason EvictReason) {
 	c.evictList.Remove(e)
 	entry := e.Value.(*lruItem)
+	reason = evictReason(c.clock, &entry.expiration, reason)
+	c.counters.evicted(reason)
+	c.listener.add(&entry.expiration, entry.value, reason)
 	delete(c.items, entry.key)
+	c.expiry.remove(&entry.expiration)
+	c.used -= entry.cost
 }
 
 type lruItem struct {
-	key      string
-	value    interface{}
-	expireAt time.Time
-}
-
-func (it *lruItem) IsExpired(clock Clock) bool {
-	return it.expireAt.Before(clock.Now())
+	expiration
+	value interface{}
+	cost  int64
 }
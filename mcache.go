@@ -3,30 +3,46 @@ package mcache
 import (
 	"context"
 	"errors"
-	"math"
+	"runtime"
 	"sync"
 	"time"
 )
 
-var p sync.Pool
-
 type cache struct {
 	clock       Clock
 	size        int
 	shardCount  int
-	shardCap    int
+	hasher      Hasher
 	defaultVal  interface{}
 	expiration  time.Duration
 	loaderFunc  LoaderFunc
 	mLoaderFunc MLoaderFunc
+	writeMode   WriteMode
+	doubleDelay time.Duration
+	lease       *Lease
+	xfetch      float64
+	ttlJitter   float64
 	redisCli    RedisCli
+	maxBytes    int64
+	sizer       Sizer
+	lfuAging    LfuAging
+	onEvict     OnEvictFunc
+	disk        *DiskStore
+	writeBehind *writeBehind
+	invalidator *invalidator
+	evictHook   func(Entry, EvictReason) // set by the handler if anything listens
+
+	sweepInterval time.Duration
+	stats         *cacheStats
+	tracer        Tracer
+	pool          *sync.Pool // options of the calls, per cache
 
 	serializeFunc   serializeFunc
 	deserializeFunc deserializeFunc
 }
 
 func (c cache) getOpt() options {
-	o, ok := p.Get().(options)
+	o, ok := c.pool.Get().(options)
 	if !ok {
 		panic("unreachable")
 	}
@@ -40,9 +56,14 @@ func (c *cache) putOpt(o options) {
 	o.LoaderFunc = c.loaderFunc
 	o.MLoaderFunc = c.mLoaderFunc
 	o.DefaultVal = c.defaultVal
+	o.WriteMode = c.writeMode
+	o.DoubleDelete = c.doubleDelay
+	o.XFetch = c.xfetch
+	o.TTLJitter = c.ttlJitter
+	o.reloadFunc = nil
 	o.serializeFunc = c.serializeFunc
 	o.deserializeFunc = c.deserializeFunc
-	p.Put(o)
+	c.pool.Put(o)
 }
 
 func (c cache) getOption(opts ...Option) options {
@@ -54,30 +75,43 @@ func (c cache) getOption(opts ...Option) options {
 
 	if o.LoaderFunc == nil {
 		if c.redisCli.Client != nil {
-			o.RealLoaderFunc = func(ctx context.Context, k string) (interface{}, error) {
+			o.RealLoaderFunc = func(ctx context.Context, k string) (interface{}, time.Duration, error) {
 				v, err := c.redisCli.get(ctx, k, o)
 				if err == nil {
-					return v, nil
+					c.stats.remoteHit(1)
+					return v, 0, nil
 				}
-				return nil, err
+				return nil, 0, err
 			}
 		}
 	} else {
-		o.RealLoaderFunc = func(ctx context.Context, k string) (interface{}, error) {
-			if v, err := c.redisCli.get(ctx, k, o); err == nil {
-				return v, nil
-			}
-
-			v, err := o.LoaderFunc(ctx, k)
+		load := func(ctx context.Context, k string) (interface{}, time.Duration, error) {
+			start := c.clock.Now()
+			lctx, sp := startSpan(ctx, c.tracer, "load", TierLoader, 1)
+			v, err := o.LoaderFunc(lctx, k)
+			sp.end(writeOutcome(err), err)
+			took := c.clock.Now().Sub(start)
+			c.stats.loaded(took, err)
 			if err == nil {
-				if err := c.redisCli.set(ctx, k, v, o); err != nil && !errors.Is(err, RedisNotFoundError) {
-					return nil, err
+				if err := c.redisCli.set(ctx, k, v, o); err != nil && !errors.Is(err, RedisNotFoundError) && !errors.Is(err, CircuitOpenError) {
+					return nil, took, err
 				}
-				return v, nil
+				return v, took, nil
 			} else if o.DefaultVal != nil {
-				return o.DefaultVal, DefaultValueSetError
+				return o.DefaultVal, took, DefaultValueSetError
 			}
-			return nil, err
+			return nil, took, err
+		}
+		o.reloadFunc = load
+		o.RealLoaderFunc = func(ctx context.Context, k string) (interface{}, time.Duration, error) {
+			if v, err := c.redisCli.get(ctx, k, o); err == nil {
+				c.stats.remoteHit(1)
+				return v, 0, nil
+			}
+			if c.lease != nil && c.redisCli.Client != nil {
+				return c.leaseLoad(ctx, k, o, load)
+			}
+			return load(ctx, k)
 		}
 	}
 
@@ -85,22 +119,31 @@ func (c cache) getOption(opts ...Option) options {
 		if c.redisCli.Client != nil {
 			o.RealMLoaderFunc = func(ctx context.Context, keys []string) (map[string]interface{}, error) {
 				result, err := c.redisCli.mget(ctx, keys, o)
+				c.stats.remoteHit(len(result))
 				return result, err
 			}
 		}
 	} else {
 		o.RealMLoaderFunc = func(ctx context.Context, keys []string) (map[string]interface{}, error) {
 			result, _ := c.redisCli.mget(ctx, keys, o)
+			c.stats.remoteHit(len(result))
 			keysG := make([]string, 0, len(keys))
 			for _, key := range keys {
 				if _, ok := result[key]; !ok {
 					keysG = append(keysG, key)
 				}
 			}
+			if len(keysG) == 0 {
+				return result, nil
+			}
 
-			val, err := o.MLoaderFunc(ctx, keysG)
+			start := c.clock.Now()
+			lctx, sp := startSpan(ctx, c.tracer, "mload", TierLoader, len(keysG))
+			val, err := o.MLoaderFunc(lctx, keysG)
+			sp.end(writeOutcome(err), err)
+			c.stats.loaded(c.clock.Now().Sub(start), err)
 			if err == nil {
-				if err := c.redisCli.msetmap(ctx, val, o); err != nil && !errors.Is(err, RedisNotFoundError) {
+				if err := c.redisCli.msetmap(ctx, val, o); err != nil && !errors.Is(err, RedisNotFoundError) && !errors.Is(err, CircuitOpenError) {
 					return nil, err
 				}
 
@@ -115,6 +158,52 @@ func (c cache) getOption(opts ...Option) options {
 	return o
 }
 
+// splitCapacity splits size between count shards, the first size%count shards
+// hold one more entry so the capacities add up to size exactly.
+func splitCapacity(size, count, i int) int {
+	n := size / count
+	if i < size%count {
+		n++
+	}
+	return n
+}
+
+// splitMaxBytes splits maxBytes between count shards like splitCapacity. A
+// shard gets at least one byte, 0 would leave it unbounded.
+func splitMaxBytes(maxBytes int64, count, i int) int64 {
+	n := maxBytes / int64(count)
+	if int64(i) < maxBytes%int64(count) {
+		n++
+	}
+	if maxBytes > 0 && n == 0 {
+		n = 1
+	}
+	return n
+}
+
+// policyOptions returns the options of a shard holding at most maxBytes.
+func (c cache) policyOptions(maxBytes int64) []PolicyOption {
+	opts := make([]PolicyOption, 0, 5)
+	if maxBytes > 0 {
+		opts = append(opts, WithPolicyMaxBytes(maxBytes))
+	}
+	if c.sizer != nil {
+		opts = append(opts, WithPolicySizer(c.sizer))
+	}
+	if c.lfuAging != (LfuAging{}) {
+		opts = append(opts, WithPolicyLfuAging(c.lfuAging))
+	}
+	if c.sweepInterval > 0 {
+		opts = append(opts, WithPolicySweep(c.sweepInterval))
+	}
+	if c.evictHook != nil {
+		opts = append(opts, func(o *policyOptions) {
+			o.OnEvict = c.evictHook
+		})
+	}
+	return opts
+}
+
 type builder[T any, P CachePolicy[T]] struct {
 	cache
 }
@@ -127,24 +216,19 @@ func New[T any, P CachePolicy[T]](size int, opts ...Option) Cache {
 
 	b := builder[T, P]{
 		cache{
-			size:  size,
-			clock: NewRealClock(),
+			size:   size,
+			clock:  NewRealClock(),
+			hasher: MemHashString,
+			stats:  newCacheStats(),
 		},
 	}
 	if b.size == 0 {
 		b.size = defaultCacheSize
 	}
-	b.shardCount = int(math.Ceil(float64(b.size) / float64(defaultShardCap)))
-	if b.shardCount == 0 {
-		b.shardCount = defaultShardCount
-	}
-	b.shardCap = b.size / b.shardCount
-	if b.shardCap == 0 {
-		b.shardCap = defaultShardCap
-	}
+	b.shardCount = shardCount(b.size, o.ShardCount)
 	b.formatByOpts(o)
 
-	p = sync.Pool{
+	b.pool = &sync.Pool{
 		New: func() interface{} {
 			return options{
 				RedisCli:        b.redisCli,
@@ -152,6 +236,10 @@ func New[T any, P CachePolicy[T]](size int, opts ...Option) Cache {
 				LoaderFunc:      b.loaderFunc,
 				MLoaderFunc:     b.mLoaderFunc,
 				DefaultVal:      b.defaultVal,
+				WriteMode:       b.writeMode,
+				DoubleDelete:    b.doubleDelay,
+				XFetch:          b.xfetch,
+				TTLJitter:       b.ttlJitter,
 				serializeFunc:   b.serializeFunc,
 				deserializeFunc: b.deserializeFunc,
 			}
@@ -161,6 +249,33 @@ func New[T any, P CachePolicy[T]](size int, opts ...Option) Cache {
 	return newCacheHandler(b)
 }
 
+// shardCount returns a power of two number of shards, so a shard index is a
+// mask of the hash. Without an explicit count there are 4 shards per
+// GOMAXPROCS, as long as every shard holds at least defaultShardCap entries.
+// There are never more shards than entries.
+func shardCount(size, count int) int {
+	if count <= 0 {
+		count = nextPowerOfTwo(runtime.GOMAXPROCS(0) * defaultShardsPerProc)
+		for count > 1 && size/count < defaultShardCap {
+			count >>= 1
+		}
+	}
+
+	count = nextPowerOfTwo(count)
+	for count > 1 && count > size {
+		count >>= 1
+	}
+	return count
+}
+
+func nextPowerOfTwo(n int) int {
+	p := 1
+	for p < n {
+		p <<= 1
+	}
+	return p
+}
+
 func (b *builder[T, P]) formatByOpts(o options) {
 	if o.LoaderFunc != nil {
 		b.loaderFunc = o.LoaderFunc
@@ -171,7 +286,19 @@ func (b *builder[T, P]) formatByOpts(o options) {
 	if o.TTL > 0 {
 		b.expiration = o.TTL
 	}
+	b.writeMode = o.WriteMode
+	b.doubleDelay = o.DoubleDelete
+	b.xfetch = o.XFetch
+	b.ttlJitter = o.TTLJitter
+	if o.Lease != nil {
+		lease := *o.Lease
+		lease.withDefaults()
+		b.lease = &lease
+	}
 	b.redisCli = o.RedisCli
+	b.redisCli.stats = b.stats.redis
+	b.redisCli.tracer = o.Tracer
+	b.tracer = o.Tracer
 	if o.serializeFunc != nil && o.deserializeFunc != nil {
 		b.serializeFunc = o.serializeFunc
 		b.deserializeFunc = o.deserializeFunc
@@ -179,4 +306,34 @@ func (b *builder[T, P]) formatByOpts(o options) {
 	if o.DefaultVal != nil {
 		b.defaultVal = o.DefaultVal
 	}
+	if o.MaxBytes > 0 {
+		b.maxBytes = o.MaxBytes
+	}
+	if o.Sizer != nil {
+		b.sizer = o.Sizer
+	}
+	b.lfuAging = o.LfuAging
+	if o.Hasher != nil {
+		b.hasher = o.Hasher
+	}
+	if o.SweepInterval > 0 {
+		b.sweepInterval = o.SweepInterval
+	}
+	if o.Clock != nil {
+		b.clock = o.Clock
+	}
+	b.onEvict = o.OnEvict
+	b.disk = o.Disk
+	if o.CircuitBreaker != nil && b.redisCli.Client != nil {
+		b.redisCli.breaker = newBreaker(*o.CircuitBreaker, b.clock)
+		cli := b.redisCli
+		b.redisCli.breaker.replay = func(ctx context.Context, keys []string) error {
+			_, err := cli.mdel(ctx, keys)
+			return err
+		}
+	}
+	b.invalidator = newInvalidator(o.InvalidationRetry, b.redisCli, b.clock)
+	if o.WriteBehind != nil && b.redisCli.Client != nil {
+		b.writeBehind = newWriteBehind(*o.WriteBehind, b.redisCli, b.clock, b.staleTTL())
+	}
 }
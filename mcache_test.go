@@ -2,6 +2,7 @@ package mcache_test
 
 import (
 	"context"
+	"errors"
 	"testing"
 
 	"github.com/alicebob/miniredis"
@@ -40,6 +41,7 @@ func TestMcacheNoRemote(t *testing.T) {
 	t.Run("lfu cache", runMcacheNoRemote[mcache.LfuCache])
 	t.Run("lru cache", runMcacheNoRemote[mcache.LruCache])
 	t.Run("arc cache", runMcacheNoRemote[mcache.ArcCache])
+	t.Run("adaptive cache", runMcacheNoRemote[mcache.AdaptiveCache])
 }
 
 func runMcacheNoRemote[T any, P mcache.CachePolicy[T]](t *testing.T) {
@@ -66,6 +68,7 @@ func TestMcacheRemote(t *testing.T) {
 	t.Run("lfu cache", runMcacheRemote[mcache.LfuCache])
 	t.Run("lru cache", runMcacheRemote[mcache.LruCache])
 	t.Run("arc cache", runMcacheNoRemote[mcache.ArcCache])
+	t.Run("adaptive cache", runMcacheRemote[mcache.AdaptiveCache])
 }
 
 func runMcacheRemote[T any, P mcache.CachePolicy[T]](t *testing.T) {
@@ -91,3 +94,54 @@ func runMcacheRemote[T any, P mcache.CachePolicy[T]](t *testing.T) {
 		assert.Equal(t, vals[i], val)
 	}
 }
+
+func TestMcacheStats(t *testing.T) {
+	var (
+		ctx    = context.TODO()
+		loader = func(ctx context.Context, key string) (interface{}, error) {
+			if key == "stats-fail" {
+				return nil, errors.New("load failed")
+			}
+			return key, nil
+		}
+		cc = mcache.New[mcache.LruCache](64, mcache.WithShardCount(2), mcache.WithRedisClient(redisClient), mcache.WithLoaderFn(loader))
+	)
+	defer cc.Close()
+	redisClient.Del(ctx, "stats-local", "stats-remote", "stats-load", "stats-fail")
+
+	assert.Nil(t, cc.Set(ctx, "stats-local", "v"))
+	_, err := cc.Get(ctx, "stats-local")
+	assert.Nil(t, err)
+
+	assert.Nil(t, redisClient.Set(ctx, "stats-remote", "v", 0).Err())
+	for i := 0; i < 2; i++ {
+		val, err := cc.Get(ctx, "stats-remote")
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("v"), val)
+	}
+
+	val, err := cc.Get(ctx, "stats-load")
+	assert.Nil(t, err)
+	assert.Equal(t, "stats-load", val)
+	_, err = cc.Get(ctx, "stats-fail")
+	assert.NotNil(t, err)
+
+	assert.True(t, cc.Remove(ctx, "stats-local"))
+
+	s := cc.Stats()
+	assert.Equal(t, uint64(2), s.LocalHits)
+	assert.Equal(t, uint64(1), s.RemoteHits)
+	assert.Equal(t, uint64(3), s.Hits)
+	assert.Equal(t, uint64(2), s.Misses)
+	assert.Equal(t, uint64(1), s.LoadSuccesses)
+	assert.Equal(t, uint64(1), s.LoadFailures)
+	assert.Equal(t, mcache.EvictionStats{Explicit: 1}, s.Evictions)
+	assert.Equal(t, 2, s.Size)
+	assert.Equal(t, 2, len(s.Shards))
+
+	size := 0
+	for _, shard := range s.Shards {
+		size += shard.Size
+	}
+	assert.Equal(t, s.Size, size)
+}
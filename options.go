@@ -12,22 +12,45 @@ import (
 
 type Option func(*options)
 
+// fillFunc loads the value of a key like a LoaderFunc, and reports how long
+// the loader function took, 0 if the value came from Redis.
+type fillFunc func(context.Context, string) (interface{}, time.Duration, error)
+
 type options struct {
-	RedisCli        RedisCli
-	TTL             time.Duration
-	LoaderFunc      LoaderFunc
-	RealLoaderFunc  LoaderFunc
-	MLoaderFunc     MLoaderFunc
-	RealMLoaderFunc MLoaderFunc
-	DefaultVal      interface{}
+	RedisCli          RedisCli
+	TTL               time.Duration
+	LoaderFunc        LoaderFunc
+	RealLoaderFunc    fillFunc
+	MLoaderFunc       MLoaderFunc
+	RealMLoaderFunc   MLoaderFunc
+	DefaultVal        interface{}
+	MaxBytes          int64
+	Sizer             Sizer
+	LfuAging          LfuAging
+	SweepInterval     time.Duration
+	ShardCount        int
+	Hasher            Hasher
+	Clock             Clock
+	Tracer            Tracer
+	OnEvict           OnEvictFunc
+	Disk              *DiskStore
+	WriteBehind       *WriteBehind
+	WriteMode         WriteMode
+	CircuitBreaker    *CircuitBreaker
+	DoubleDelete      time.Duration
+	InvalidationRetry InvalidationRetry
+	Lease             *Lease
+	XFetch            float64
+	TTLJitter         float64
 
+	reloadFunc      fillFunc // runs LoaderFunc for an early reload
 	serializeFunc   serializeFunc
 	deserializeFunc deserializeFunc
 }
 
 func WithRedisClient(client *redis.Client) Option {
 	return func(o *options) {
-		o.RedisCli = RedisCli{client}
+		o.RedisCli = RedisCli{Client: client}
 	}
 }
 
@@ -104,6 +127,202 @@ func WithDefaultVal(defaultVal interface{}) Option {
 
 func WithRedisOptions(opts *redis.Options) Option {
 	return func(o *options) {
-		o.RedisCli = RedisCli{redis.NewClient(opts)}
+		o.RedisCli = RedisCli{Client: redis.NewClient(opts)}
+	}
+}
+
+// WithMaxBytes bounds the memory used by the local shards. The budget is split
+// evenly between shards and each shard evicts until its entries fit.
+func WithMaxBytes(maxBytes int64) Option {
+	return func(o *options) {
+		o.MaxBytes = maxBytes
+	}
+}
+
+// WithSizer overrides the estimator used to weigh values for WithMaxBytes.
+func WithSizer(sizer Sizer) Option {
+	return func(o *options) {
+		o.Sizer = sizer
+	}
+}
+
+// WithLfuAging makes LfuCache shards forget old popularity, see LfuAging.
+func WithLfuAging(aging LfuAging) Option {
+	return func(o *options) {
+		o.LfuAging = aging
+	}
+}
+
+// WithExpirationSweep removes expired entries in the background every
+// interval, instead of only when they are touched or evicted. Deadlines are
+// then indexed in a timing wheel with interval as resolution. The sweep stops
+// on Close.
+func WithExpirationSweep(interval time.Duration) Option {
+	return func(o *options) {
+		o.SweepInterval = interval
+	}
+}
+
+// WithShardCount sets the number of local shards, it is rounded up to a power
+// of two and down to the cache size. The capacity is split between the shards
+// so it still adds up to the cache size.
+func WithShardCount(n int) Option {
+	return func(o *options) {
+		o.ShardCount = n
+	}
+}
+
+// WithHasher sets the hash used to pick the shard of a key. The default
+// MemHashString is seeded per process, use XXHashString, FnvHashString,
+// XXHashStringSeed or any other stable hash for a shard placement reproducible
+// across runs.
+func WithHasher(hasher Hasher) Option {
+	return func(o *options) {
+		o.Hasher = hasher
+	}
+}
+
+// WithClock sets the clock deadlines are computed and checked with, instead of
+// NewRealClock. The cache doesn't stop the clock on Close, so a CoarseClock can
+// be shared between caches.
+func WithClock(clock Clock) Option {
+	return func(o *options) {
+		o.Clock = clock
+	}
+}
+
+// WithOnEvict calls fn with every entry leaving the local shards and why, on
+// the goroutine that removed it but after the shard lock is released. fn may
+// read from the cache, writing to it from fn can deadlock while Resize
+// migrates entries.
+func WithOnEvict(fn OnEvictFunc) Option {
+	return func(o *options) {
+		o.OnEvict = fn
+	}
+}
+
+// WithDiskTier demotes the entries evicted from the local shards for lack of
+// room to store, and promotes them back on a local miss before Redis or the
+// loader are asked. The values are encoded with the codec of the cache, which
+// is required. The cache doesn't close store.
+func WithDiskTier(store *DiskStore) Option {
+	return func(o *options) {
+		o.Disk = store
+	}
+}
+
+// WriteMode selects the tiers written by Set, MSet, Remove and MRemove. Without
+// a Redis client every mode only updates the local shards.
+type WriteMode int
+
+const (
+	// WriteThrough writes Redis, then the local shards. A Redis failure is
+	// returned and leaves the local shards as they were.
+	WriteThrough WriteMode = iota
+	// WriteAround writes Redis only and drops the local copies of the keys,
+	// the next Get loads them from Redis. A Redis failure is returned and
+	// leaves the local shards as they were. The Redis write is never queued
+	// by WithWriteBehind.
+	WriteAround
+	// WriteLocal writes the local shards only, Redis keeps its previous
+	// value.
+	WriteLocal
+	// WriteBestEffort writes the local shards, then tries Redis. A Redis
+	// failure is not returned, it is only counted in Stats.Redis.
+	WriteBestEffort
+)
+
+// WithWriteMode sets the WriteMode, WriteThrough by default. Remove and
+// MRemove drop the local copies whatever the mode, and Redis unless the mode
+// is WriteLocal.
+func WithWriteMode(mode WriteMode) Option {
+	return func(o *options) {
+		o.WriteMode = mode
+	}
+}
+
+// WithWriteBehind makes Set and MSet in WriteThrough or WriteBestEffort mode
+// update the local shards and queue the Redis writes, which are coalesced per
+// key and flushed in the background, see WriteBehind. A Get of a key queued or
+// in flight returns the queued value. Close flushes the queue a last time, the
+// Sets after Close write Redis synchronously.
+func WithWriteBehind(cfg WriteBehind) Option {
+	return func(o *options) {
+		o.WriteBehind = &cfg
+	}
+}
+
+// WithCircuitBreaker stops calling Redis once too many calls fail or are slow,
+// see CircuitBreaker. While the circuit is open the cache only uses the local
+// shards: Set, MSet, Remove and MRemove succeed locally whatever the
+// WriteMode, and their keys are deleted from Redis once the circuit closes,
+// so Redis doesn't serve stale values. Redis is probed again after
+// OpenTimeout.
+func WithCircuitBreaker(cfg CircuitBreaker) Option {
+	return func(o *options) {
+		o.CircuitBreaker = &cfg
+	}
+}
+
+// WithDoubleDelete makes Invalidate remove the keys a second time after delay,
+// once the concurrent readers that loaded the values before the first delete
+// are done.
+func WithDoubleDelete(delay time.Duration) Option {
+	return func(o *options) {
+		o.DoubleDelete = delay
+	}
+}
+
+// WithInvalidationRetry configures how the failed Redis deletes of Invalidate
+// are retried, see InvalidationRetry.
+func WithInvalidationRetry(cfg InvalidationRetry) Option {
+	return func(o *options) {
+		o.InvalidationRetry = cfg
+	}
+}
+
+// WithLease protects the loader against stampedes across processes: on a miss
+// of Get in the local shards and in Redis, LoaderFunc only runs in the process
+// holding a short lease of the key in Redis, while the others wait for the
+// value or serve a stale copy of it, see Lease. A stale copy is returned by Get
+// with StaleValueError and not kept locally.
+func WithLease(cfg Lease) Option {
+	return func(o *options) {
+		o.Lease = &cfg
+	}
+}
+
+// WithXFetch makes Get reload a live entry ahead of its expiration now and
+// then, so the keys set together aren't all reloaded as they expire. The
+// closer the deadline and the longer the value of the entry took to load, the
+// likelier an early reload: it happens once
+//
+//	now - beta * loadTime * ln(rand()) >= deadline
+//
+// where loadTime is measured with the clock of the cache when Get runs
+// LoaderFunc. Only the entries loaded by Get have one. A beta of 1 suits most
+// loads, a greater one reloads earlier and 0 disables the early reloads. The
+// reload runs LoaderFunc in the calling Get, if it fails the live value is
+// returned.
+func WithXFetch(beta float64) Option {
+	return func(o *options) {
+		o.XFetch = beta
+	}
+}
+
+// WithTTLJitter shortens the TTL of every key written by Set, MSet or a load by
+// up to jitter of it, between 0 and 1, so the keys written together don't
+// expire together. The TTL of a key only depends on the key within a process,
+// its local and Redis copies expire together.
+func WithTTLJitter(jitter float64) Option {
+	return func(o *options) {
+		o.TTLJitter = jitter
+	}
+}
+
+// WithTracer traces the operations of the cache with tracer.
+func WithTracer(tracer Tracer) Option {
+	return func(o *options) {
+		o.Tracer = tracer
 	}
 }
@@ -2,6 +2,8 @@ package mcache
 
 import (
 	"context"
+	"fmt"
+	"math/rand"
 	"testing"
 	"time"
 
@@ -13,6 +15,7 @@ func TestCache(t *testing.T) {
 	t.Run("lfu cache", runCachePolicy[LfuCache])
 	t.Run("lru cache", runCachePolicy[LruCache])
 	t.Run("arc cache", runCachePolicy[ArcCache])
+	t.Run("adaptive cache", runCachePolicy[AdaptiveCache])
 }
 
 func runCachePolicy[T any, P CachePolicy[T]](t *testing.T) {
@@ -42,11 +45,300 @@ func runCachePolicy[T any, P CachePolicy[T]](t *testing.T) {
 	assert.NotNil(t, err)
 	assert.Nil(t, val)
 
-	assert.True(t, cc.Remove(ctx, "key"))
-	assert.False(t, cc.Remove(ctx, "k"))
+	assert.True(t, cc.Remove(ctx, "key", EvictExplicit))
+	assert.False(t, cc.Remove(ctx, "k", EvictExplicit))
 
 	cc.Init(fc, 1)
 	assert.Nil(t, cc.Set(ctx, "ak", "av", 0))
 	cc.Evict(ctx, 1)
 	assert.False(t, cc.Exists(ctx, "ak"))
 }
+
+func TestCacheMaxBytes(t *testing.T) {
+	t.Run("simple cache", runCachePolicyMaxBytes[SimpleCache])
+	t.Run("lfu cache", runCachePolicyMaxBytes[LfuCache])
+	t.Run("lru cache", runCachePolicyMaxBytes[LruCache])
+	t.Run("arc cache", runCachePolicyMaxBytes[ArcCache])
+	t.Run("adaptive cache", runCachePolicyMaxBytes[AdaptiveCache])
+}
+
+func runCachePolicyMaxBytes[T any, P CachePolicy[T]](t *testing.T) {
+	var (
+		ctx = context.TODO()
+		fc  = NewFakeClock()
+		cc  = P(new(T))
+		val = string(make([]byte, 30))
+	)
+	// every entry weighs len("kN")+30 = 32 bytes, so only 3 fit in 100 bytes.
+	cc.Init(fc, 100, WithPolicyMaxBytes(100))
+
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, cc.Set(ctx, fmt.Sprintf("k%d", i), val, 0))
+	}
+
+	var n int
+	for i := 0; i < 10; i++ {
+		if cc.Exists(ctx, fmt.Sprintf("k%d", i)) {
+			n++
+		}
+	}
+	assert.Equal(t, 3, n)
+	assert.True(t, cc.Exists(ctx, "k9"))
+
+	assert.Equal(t, ValueTooLargeError, cc.Set(ctx, "big", string(make([]byte, 101)), 0))
+
+	// an entry growing to fill the budget evicts the others, never itself.
+	cc = P(new(T))
+	cc.Init(fc, 8, WithPolicyMaxBytes(100))
+	for _, set := range []struct {
+		key string
+		n   int
+	}{{"k0", 93}, {"k1", 44}, {"k0", 14}, {"k0", 93}} {
+		assert.Nil(t, cc.Set(ctx, set.key, string(make([]byte, set.n)), 0))
+		assert.True(t, cc.Exists(ctx, set.key))
+	}
+}
+
+func TestLfuAging(t *testing.T) {
+	const (
+		capacity = 10
+		bound    = 5000
+	)
+
+	// learn counts the operations needed until the whole new hot set is
+	// resident, after the old hot set was accessed heavily.
+	learn := func(aging LfuAging, tick time.Duration) int {
+		var (
+			ctx = context.TODO()
+			fc  = NewFakeClock()
+			cc  = new(LfuCache)
+		)
+		cc.Init(fc, capacity, WithPolicyLfuAging(aging))
+
+		for i := 0; i < 1000; i++ {
+			key := fmt.Sprintf("old%d", i%capacity)
+			if _, err := cc.Get(ctx, key); err != nil {
+				cc.Set(ctx, key, i, 0)
+			}
+			fc.Advance(tick)
+		}
+
+		for i := 0; i < bound; i++ {
+			key := fmt.Sprintf("new%d", i%capacity)
+			if _, err := cc.Get(ctx, key); err != nil {
+				cc.Set(ctx, key, i, 0)
+			}
+			fc.Advance(tick)
+
+			resident := 0
+			for j := 0; j < capacity; j++ {
+				if cc.Exists(ctx, fmt.Sprintf("new%d", j)) {
+					resident++
+				}
+			}
+			if resident == capacity {
+				return i
+			}
+		}
+		return bound
+	}
+
+	assert.Equal(t, bound, learn(LfuAging{}, 0))
+	assert.Less(t, learn(LfuAging{HalveEvery: 50}, 0), 1000)
+	assert.Less(t, learn(LfuAging{LogFactor: 10, DecayPeriod: time.Second}, 100*time.Millisecond), 1000)
+}
+
+func TestCacheExpiredFirst(t *testing.T) {
+	t.Run("simple cache", runCachePolicyExpiredFirst[SimpleCache])
+	t.Run("lfu cache", runCachePolicyExpiredFirst[LfuCache])
+	t.Run("lru cache", runCachePolicyExpiredFirst[LruCache])
+	t.Run("arc cache", runCachePolicyExpiredFirst[ArcCache])
+	t.Run("adaptive cache", runCachePolicyExpiredFirst[AdaptiveCache])
+}
+
+func runCachePolicyExpiredFirst[T any, P CachePolicy[T]](t *testing.T) {
+	var (
+		ctx = context.TODO()
+		fc  = NewFakeClock()
+		cc  = P(new(T))
+	)
+	cc.Init(fc, 3)
+
+	assert.Nil(t, cc.Set(ctx, "b", "b", 0))
+	assert.Nil(t, cc.Set(ctx, "c", "c", 0))
+	assert.Nil(t, cc.Set(ctx, "a", "a", 100*time.Millisecond))
+	for i := 0; i < 3; i++ {
+		cc.Get(ctx, "a")
+	}
+
+	// a is the most recently and frequently used entry, but it is dead.
+	fc.Advance(101 * time.Millisecond)
+	assert.Nil(t, cc.Set(ctx, "d", "d", 0))
+	assert.True(t, cc.Exists(ctx, "b"))
+	assert.True(t, cc.Exists(ctx, "c"))
+	assert.True(t, cc.Exists(ctx, "d"))
+	assert.False(t, cc.Exists(ctx, "a"))
+}
+
+func TestCacheSweep(t *testing.T) {
+	t.Run("simple cache", runCachePolicySweep[SimpleCache])
+	t.Run("lfu cache", runCachePolicySweep[LfuCache])
+	t.Run("lru cache", runCachePolicySweep[LruCache])
+	t.Run("arc cache", runCachePolicySweep[ArcCache])
+	t.Run("adaptive cache", runCachePolicySweep[AdaptiveCache])
+}
+
+func runCachePolicySweep[T any, P CachePolicy[T]](t *testing.T) {
+	var (
+		ctx = context.TODO()
+		fc  = NewFakeClock()
+		cc  = P(new(T))
+	)
+	cc.Init(fc, 100, WithPolicySweep(10*time.Millisecond))
+
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, cc.Set(ctx, fmt.Sprintf("k%d", i), i, time.Duration(i+1)*100*time.Millisecond))
+	}
+	assert.Nil(t, cc.Set(ctx, "forever", "v", 0))
+	assert.Equal(t, 0, cc.Sweep(ctx))
+
+	fc.Advance(550 * time.Millisecond)
+	assert.Equal(t, 5, cc.Sweep(ctx))
+	assert.False(t, cc.Exists(ctx, "k4"))
+	assert.True(t, cc.Exists(ctx, "k5"))
+
+	fc.Advance(time.Hour)
+	assert.Equal(t, 5, cc.Sweep(ctx))
+	assert.True(t, cc.Exists(ctx, "forever"))
+}
+
+func TestCacheStats(t *testing.T) {
+	t.Run("simple cache", runCachePolicyStats[SimpleCache])
+	t.Run("lfu cache", runCachePolicyStats[LfuCache])
+	t.Run("lru cache", runCachePolicyStats[LruCache])
+	t.Run("arc cache", runCachePolicyStats[ArcCache])
+	t.Run("adaptive cache", runCachePolicyStats[AdaptiveCache])
+}
+
+func runCachePolicyStats[T any, P CachePolicy[T]](t *testing.T) {
+	var (
+		ctx = context.TODO()
+		fc  = NewFakeClock()
+		cc  = P(new(T))
+	)
+	cc.Init(fc, 2)
+
+	assert.Nil(t, cc.Set(ctx, "a", "a", 0))
+	assert.Nil(t, cc.Set(ctx, "b", "b", 0))
+	cc.Get(ctx, "a")
+	cc.Get(ctx, "z")
+	assert.Nil(t, cc.Set(ctx, "c", "c", 100*time.Millisecond))
+
+	fc.Advance(101 * time.Millisecond)
+	assert.Equal(t, 1, cc.Sweep(ctx))
+	assert.True(t, cc.Remove(ctx, "a", EvictExplicit) || cc.Remove(ctx, "b", EvictExplicit))
+
+	s := cc.Stats()
+	assert.Equal(t, uint64(1), s.Hits)
+	assert.Equal(t, uint64(1), s.Misses)
+	assert.Equal(t, EvictionStats{Capacity: 1, Expired: 1, Explicit: 1}, s.Evictions)
+	assert.Equal(t, 0, s.Size)
+}
+
+func TestCacheOnEvict(t *testing.T) {
+	t.Run("simple cache", runCachePolicyOnEvict[SimpleCache])
+	t.Run("lfu cache", runCachePolicyOnEvict[LfuCache])
+	t.Run("lru cache", runCachePolicyOnEvict[LruCache])
+	t.Run("arc cache", runCachePolicyOnEvict[ArcCache])
+	t.Run("adaptive cache", runCachePolicyOnEvict[AdaptiveCache])
+}
+
+func runCachePolicyOnEvict[T any, P CachePolicy[T]](t *testing.T) {
+	var (
+		ctx     = context.TODO()
+		fc      = NewFakeClock()
+		cc      = P(new(T))
+		reasons []EvictReason
+	)
+	cc.Init(fc, 2, WithPolicyOnEvict(func(key string, value interface{}, reason EvictReason) {
+		// the shard lock is released, so the shard can be read.
+		cc.Exists(ctx, key)
+		if reason == EvictReplaced {
+			assert.Equal(t, "a", key)
+			assert.Equal(t, "a", value)
+		}
+		reasons = append(reasons, reason)
+	}))
+
+	assert.Nil(t, cc.Set(ctx, "a", "a", 0))
+	assert.Nil(t, cc.Set(ctx, "b", "b", 0))
+	assert.Nil(t, cc.Set(ctx, "a", "a2", 0))
+	assert.Nil(t, cc.Set(ctx, "c", "c", 100*time.Millisecond))
+
+	fc.Advance(101 * time.Millisecond)
+	assert.Equal(t, 1, cc.Sweep(ctx))
+	assert.True(t, cc.Remove(ctx, "a", EvictExplicit) || cc.Remove(ctx, "b", EvictExplicit))
+	assert.False(t, cc.Remove(ctx, "z", EvictExplicit))
+	cc.Remove(ctx, "a", evictMigrated)
+	cc.Remove(ctx, "b", evictMigrated)
+
+	assert.Equal(t, []EvictReason{EvictReplaced, EvictCapacity, EvictExpired, EvictExplicit}, reasons)
+	assert.Equal(t, EvictionStats{Capacity: 1, Expired: 1, Explicit: 1, Replaced: 1}, cc.Stats().Evictions)
+}
+
+func TestCacheReadReplay(t *testing.T) {
+	t.Run("lfu cache", runCachePolicyReadReplay[LfuCache])
+	t.Run("lru cache", runCachePolicyReadReplay[LruCache])
+	t.Run("arc cache", runCachePolicyReadReplay[ArcCache])
+	t.Run("adaptive cache", runCachePolicyReadReplay[AdaptiveCache])
+}
+
+func runCachePolicyReadReplay[T any, P CachePolicy[T]](t *testing.T) {
+	var (
+		ctx = context.TODO()
+		cc  = P(new(T))
+	)
+	cc.Init(NewFakeClock(), 3)
+
+	// the hit on k0 is only buffered, the next Set replays it before evicting.
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, cc.Set(ctx, fmt.Sprintf("k%d", i), i, 0))
+	}
+	_, err := cc.Get(ctx, "k0")
+	assert.Nil(t, err)
+	assert.Nil(t, cc.Set(ctx, "k3", 3, 0))
+	assert.True(t, cc.Exists(ctx, "k0"))
+	assert.Equal(t, 3, cc.Stats().Size)
+}
+
+func TestAdaptiveCacheMix(t *testing.T) {
+	const capacity = 512
+
+	var ctx = context.TODO()
+	access := func(cc *AdaptiveCache, key string) {
+		if _, err := cc.Get(ctx, key); err != nil {
+			cc.Set(ctx, key, key, 0)
+		}
+	}
+
+	// a small hot set drowned in a scan of keys never seen again, only
+	// frequency keeps the hot set.
+	freq := new(AdaptiveCache)
+	freq.Init(NewFakeClock(), capacity)
+	for i := 0; i < 50000; i++ {
+		if i%10 == 0 {
+			access(freq, fmt.Sprintf("hot%d", rand.Intn(100)))
+		} else {
+			access(freq, fmt.Sprintf("scan%d", i))
+		}
+	}
+	assert.Less(t, freq.Stats().Adaptive.LruShare, 0.5)
+
+	// a working set sliding forward, keys that were popular before are dead.
+	recency := new(AdaptiveCache)
+	recency.Init(NewFakeClock(), capacity)
+	for i := 0; i < 50000; i++ {
+		access(recency, fmt.Sprintf("k%d", i/10+rand.Intn(capacity/2)))
+	}
+	assert.Greater(t, recency.Stats().Adaptive.LruShare, 0.5)
+}
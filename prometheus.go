@@ -0,0 +1,260 @@
+package mcache
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Registry is an http.Handler exposing the Stats of named caches in the
+// Prometheus text exposition format, every sample is labelled with the name
+// of its cache.
+type Registry struct {
+	mu     sync.RWMutex
+	caches map[string]Cache
+}
+
+func NewRegistry() *Registry {
+	return &Registry{caches: make(map[string]Cache)}
+}
+
+// Register exposes c under name, it fails if name is already registered.
+func (r *Registry) Register(name string, c Cache) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.caches[name]; ok {
+		return DuplicateCacheError
+	}
+	r.caches[name] = c
+	return nil
+}
+
+func (r *Registry) Unregister(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.caches, name)
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var buf bytes.Buffer
+	r.write(&buf)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+type namedStats struct {
+	name string
+	Stats
+}
+
+func (r *Registry) write(buf *bytes.Buffer) {
+	r.mu.RLock()
+	all := make([]namedStats, 0, len(r.caches))
+	for name, c := range r.caches {
+		all = append(all, namedStats{name: name, Stats: c.Stats()})
+	}
+	r.mu.RUnlock()
+	sort.Slice(all, func(i, j int) bool { return all[i].name < all[j].name })
+
+	p := promWriter{buf: buf}
+
+	p.family("mcache_hits_total", "counter", "Gets served from the local shards, the disk tier or Redis.")
+	for _, s := range all {
+		p.sample("mcache_hits_total", labels("cache", s.name, "tier", "local"), float64(s.LocalHits))
+		p.sample("mcache_hits_total", labels("cache", s.name, "tier", "disk"), float64(s.DiskHits))
+		p.sample("mcache_hits_total", labels("cache", s.name, "tier", "remote"), float64(s.RemoteHits))
+	}
+
+	p.family("mcache_misses_total", "counter", "Keys found neither locally nor remotely.")
+	for _, s := range all {
+		p.sample("mcache_misses_total", labels("cache", s.name), float64(s.Misses))
+	}
+
+	p.family("mcache_loads_total", "counter", "Calls to the loader functions.")
+	for _, s := range all {
+		p.sample("mcache_loads_total", labels("cache", s.name, "result", "success"), float64(s.LoadSuccesses))
+		p.sample("mcache_loads_total", labels("cache", s.name, "result", "failure"), float64(s.LoadFailures))
+	}
+
+	p.family("mcache_load_duration_seconds", "histogram", "Latency of the loader functions.")
+	for _, s := range all {
+		p.histogram("mcache_load_duration_seconds", labels("cache", s.name), s.LoadLatency)
+	}
+
+	p.family("mcache_early_reloads_total", "counter", "Live entries reloaded ahead of their expiration.")
+	for _, s := range all {
+		p.sample("mcache_early_reloads_total", labels("cache", s.name), float64(s.EarlyReloads))
+	}
+
+	p.family("mcache_redis_errors_total", "counter", "Failed Redis calls, a pipeline counts as one call.")
+	for _, s := range all {
+		for _, op := range redisOpNames {
+			p.sample("mcache_redis_errors_total", labels("cache", s.name, "op", op), float64(s.Redis[op].Errors))
+		}
+	}
+
+	p.family("mcache_redis_duration_seconds", "histogram", "Latency of the Redis calls, a pipeline counts as one call.")
+	for _, s := range all {
+		for _, op := range redisOpNames {
+			p.histogram("mcache_redis_duration_seconds", labels("cache", s.name, "op", op), s.Redis[op].Latency)
+		}
+	}
+
+	p.family("mcache_write_behind_pending", "gauge", "Keys queued for a write-behind to Redis.")
+	for _, s := range all {
+		p.sample("mcache_write_behind_pending", labels("cache", s.name), float64(s.WriteBehind.Pending))
+	}
+
+	p.family("mcache_write_behind_writes_total", "counter", "Write-behind writes flushed to Redis, queued again or dropped.")
+	for _, s := range all {
+		p.sample("mcache_write_behind_writes_total", labels("cache", s.name, "result", "flushed"), float64(s.WriteBehind.Flushed))
+		p.sample("mcache_write_behind_writes_total", labels("cache", s.name, "result", "retried"), float64(s.WriteBehind.Retried))
+		p.sample("mcache_write_behind_writes_total", labels("cache", s.name, "result", "dropped"), float64(s.WriteBehind.Dropped))
+	}
+
+	p.family("mcache_breaker_state", "gauge", "State of the Redis circuit breaker, 1 for the current state.")
+	for _, s := range all {
+		for _, state := range []BreakerState{BreakerClosed, BreakerOpen, BreakerHalfOpen} {
+			v := 0.0
+			if s.Breaker.State == state {
+				v = 1
+			}
+			p.sample("mcache_breaker_state", labels("cache", s.name, "state", state.String()), v)
+		}
+	}
+
+	p.family("mcache_breaker_trips_total", "counter", "Times the Redis circuit breaker opened.")
+	for _, s := range all {
+		p.sample("mcache_breaker_trips_total", labels("cache", s.name), float64(s.Breaker.Trips))
+	}
+
+	p.family("mcache_breaker_rejected_total", "counter", "Redis calls short-circuited by the open breaker.")
+	for _, s := range all {
+		p.sample("mcache_breaker_rejected_total", labels("cache", s.name), float64(s.Breaker.Rejected))
+	}
+
+	p.family("mcache_breaker_invalidations", "gauge", "Redis deletes queued until the breaker closes.")
+	for _, s := range all {
+		p.sample("mcache_breaker_invalidations", labels("cache", s.name), float64(s.Breaker.Invalidations))
+	}
+
+	p.family("mcache_invalidations_pending", "gauge", "Keys waiting for a retry of their Redis delete.")
+	for _, s := range all {
+		p.sample("mcache_invalidations_pending", labels("cache", s.name), float64(s.Invalidation.Pending))
+	}
+
+	p.family("mcache_invalidation_retries_total", "counter", "Failed Redis deletes of Invalidate queued for a retry or dropped.")
+	for _, s := range all {
+		p.sample("mcache_invalidation_retries_total", labels("cache", s.name, "result", "retried"), float64(s.Invalidation.Retried))
+		p.sample("mcache_invalidation_retries_total", labels("cache", s.name, "result", "dropped"), float64(s.Invalidation.Dropped))
+	}
+
+	p.family("mcache_evictions_total", "counter", "Entries evicted from the local shards.")
+	for _, s := range all {
+		p.sample("mcache_evictions_total", labels("cache", s.name, "reason", EvictCapacity.String()), float64(s.Evictions.Capacity))
+		p.sample("mcache_evictions_total", labels("cache", s.name, "reason", EvictExpired.String()), float64(s.Evictions.Expired))
+		p.sample("mcache_evictions_total", labels("cache", s.name, "reason", EvictExplicit.String()), float64(s.Evictions.Explicit))
+		p.sample("mcache_evictions_total", labels("cache", s.name, "reason", EvictReplaced.String()), float64(s.Evictions.Replaced))
+	}
+
+	p.family("mcache_entries", "gauge", "Entries held by the local shards.")
+	for _, s := range all {
+		p.sample("mcache_entries", labels("cache", s.name), float64(s.Size))
+	}
+
+	p.family("mcache_shard_entries", "gauge", "Entries held by each local shard.")
+	for _, s := range all {
+		for i, shard := range s.Shards {
+			p.sample("mcache_shard_entries", labels("cache", s.name, "shard", strconv.Itoa(i)), float64(shard.Size))
+		}
+	}
+
+	p.family("mcache_shard_hits_total", "counter", "Hits of each local shard.")
+	for _, s := range all {
+		for i, shard := range s.Shards {
+			p.sample("mcache_shard_hits_total", labels("cache", s.name, "shard", strconv.Itoa(i)), float64(shard.Hits))
+		}
+	}
+
+	p.family("mcache_shard_misses_total", "counter", "Misses of each local shard.")
+	for _, s := range all {
+		for i, shard := range s.Shards {
+			p.sample("mcache_shard_misses_total", labels("cache", s.name, "shard", strconv.Itoa(i)), float64(shard.Misses))
+		}
+	}
+
+	p.family("mcache_adaptive_lru_share", "gauge", "Share of the evictions of an AdaptiveCache shard decided by recency.")
+	for _, s := range all {
+		for i, shard := range s.Shards {
+			if shard.Adaptive != nil {
+				p.sample("mcache_adaptive_lru_share", labels("cache", s.name, "shard", strconv.Itoa(i)), shard.Adaptive.LruShare)
+			}
+		}
+	}
+}
+
+// promWriter renders the text exposition format.
+type promWriter struct {
+	buf *bytes.Buffer
+}
+
+func (p promWriter) family(name, typ, help string) {
+	fmt.Fprintf(p.buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func (p promWriter) sample(name, labels string, v float64) {
+	p.buf.WriteString(name)
+	p.buf.WriteString(labels)
+	p.buf.WriteByte(' ')
+	p.buf.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+	p.buf.WriteByte('\n')
+}
+
+func (p promWriter) histogram(name, labels string, h Histogram) {
+	var cumulative uint64
+	prefix := strings.TrimSuffix(labels, "}") + ","
+	for i, bound := range LatencyBuckets {
+		if i < len(h.Counts) {
+			cumulative += h.Counts[i]
+		}
+		p.sample(name+"_bucket", prefix+`le="`+formatSeconds(bound)+`"}`, float64(cumulative))
+	}
+	p.sample(name+"_bucket", prefix+`le="+Inf"}`, float64(h.Count))
+	p.sample(name+"_sum", labels, h.Sum.Seconds())
+	p.sample(name+"_count", labels, float64(h.Count))
+}
+
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'g', -1, 64)
+}
+
+// labels renders name/value pairs as a label set.
+func labels(pairs ...string) string {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(pairs[i])
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(pairs[i+1]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
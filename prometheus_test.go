@@ -0,0 +1,75 @@
+package mcache
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry(t *testing.T) {
+	var (
+		ctx      = context.TODO()
+		registry = NewRegistry()
+		loader   = func(ctx context.Context, key string) (interface{}, error) {
+			return key, nil
+		}
+		users    = New[LruCache](64, WithShardCount(2), WithLoaderFn(loader))
+		sessions = New[AdaptiveCache](64, WithShardCount(1))
+	)
+	defer users.Close()
+	defer sessions.Close()
+
+	assert.Nil(t, registry.Register("users", users))
+	assert.Nil(t, registry.Register(`se"ss`, sessions))
+	assert.Equal(t, DuplicateCacheError, registry.Register("users", users))
+
+	assert.Nil(t, users.Set(ctx, "a", "a"))
+	users.Get(ctx, "a")
+	users.Get(ctx, "b")
+	sessions.Get(ctx, "a")
+
+	rec := httptest.NewRecorder()
+	registry.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", rec.Header().Get("Content-Type"))
+
+	body := rec.Body.String()
+	for _, line := range []string{
+		`# TYPE mcache_hits_total counter`,
+		`mcache_hits_total{cache="users",tier="local"} 1`,
+		`mcache_misses_total{cache="users"} 1`,
+		`mcache_misses_total{cache="se\"ss"} 1`,
+		`mcache_loads_total{cache="users",result="success"} 1`,
+		`mcache_loads_total{cache="se\"ss",result="success"} 0`,
+		`# TYPE mcache_load_duration_seconds histogram`,
+		`mcache_load_duration_seconds_bucket{cache="users",le="+Inf"} 1`,
+		`mcache_load_duration_seconds_count{cache="users"} 1`,
+		`mcache_redis_duration_seconds_count{cache="users",op="mget"} 0`,
+		`mcache_entries{cache="users"} 2`,
+		`mcache_shard_entries{cache="users",shard="1"} `,
+		`mcache_adaptive_lru_share{cache="se\"ss",shard="0"} 0.5`,
+	} {
+		assert.Contains(t, body, line)
+	}
+
+	registry.Unregister(`se"ss`)
+	rec = httptest.NewRecorder()
+	registry.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.NotContains(t, rec.Body.String(), `se\"ss`)
+}
+
+func TestHistogram(t *testing.T) {
+	h := newHistogram()
+	h.observe(50 * time.Microsecond)
+	h.observe(time.Millisecond)
+	h.observe(time.Minute)
+
+	s := h.snapshot()
+	assert.Equal(t, uint64(3), s.Count)
+	assert.Equal(t, uint64(1), s.Counts[0])
+	assert.Equal(t, uint64(1), s.Counts[3])
+	assert.Equal(t, uint64(1), s.Counts[len(LatencyBuckets)])
+	assert.Equal(t, time.Minute+time.Millisecond+50*time.Microsecond, s.Sum)
+}
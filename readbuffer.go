@@ -0,0 +1,84 @@
+package mcache
+
+import (
+	"runtime"
+	"sync/atomic"
+	"unsafe"
+)
+
+const (
+	readBufferSize       = 1 << 4 //每个条带缓冲的访问事件数
+	readBufferMaxStripes = 1 << 4 //每个分片最多的条带数
+)
+
+// readBuffer records the hits of a policy shard, so Get only takes the read
+// lock. A hit is offered without locking to a stripe picked by stripe and
+// replayed into the policy later by whoever holds the write lock. The buffer
+// is lossy: a hit offered to a full or contended stripe is dropped, which only
+// costs the policy a little precision on the hottest entries.
+type readBuffer[E any] struct {
+	stripes []readStripe
+}
+
+type readStripe struct {
+	head  uint64 // next slot to replay, only written under the write lock
+	tail  uint64 // next slot to fill
+	slots [readBufferSize]unsafe.Pointer
+	_     [64]byte // keeps stripes off each other's cache lines
+}
+
+func (b *readBuffer[E]) init() {
+	n := nextPowerOfTwo(runtime.GOMAXPROCS(0))
+	if n > readBufferMaxStripes {
+		n = readBufferMaxStripes
+	}
+	b.stripes = make([]readStripe, n)
+}
+
+// offer records a hit on e, it reports whether the stripe is full and should
+// be drained.
+func (b *readBuffer[E]) offer(e *E) bool {
+	s := b.stripe(e)
+
+	head := atomic.LoadUint64(&s.head)
+	tail := atomic.LoadUint64(&s.tail)
+	if tail-head >= readBufferSize {
+		return true
+	}
+	if !atomic.CompareAndSwapUint64(&s.tail, tail, tail+1) {
+		return false
+	}
+	atomic.StorePointer(&s.slots[tail&(readBufferSize-1)], unsafe.Pointer(e))
+	return tail+1-head >= readBufferSize
+}
+
+// stripe picks the stripe of a hit on e by hashing the address of e with one
+// on the stack of the caller. Goroutines run on separate stacks, so readers of
+// the same entry spread over the stripes, and picking one writes nothing
+// shared.
+func (b *readBuffer[E]) stripe(e *E) *readStripe {
+	var local byte
+	h := uint64(uintptr(unsafe.Pointer(e))^uintptr(unsafe.Pointer(&local))) * 0x9e3779b97f4a7c15
+	return &b.stripes[(h>>32)&uint64(len(b.stripes)-1)]
+}
+
+// drain replays the recorded hits in order per stripe, it must be called with
+// the write lock held.
+func (b *readBuffer[E]) drain(fn func(e *E)) {
+	for i := range b.stripes {
+		s := &b.stripes[i]
+		head := s.head
+		tail := atomic.LoadUint64(&s.tail)
+		for ; head != tail; head++ {
+			slot := &s.slots[head&(readBufferSize-1)]
+			p := atomic.LoadPointer(slot)
+			if p == nil {
+				// the offer is still in flight, pick it up next time.
+				break
+			}
+			atomic.StorePointer(slot, nil)
+			fn((*E)(p))
+		}
+		atomic.StoreUint64(&s.head, head)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"reflect"
+	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/vmihailenco/msgpack/v5"
@@ -18,6 +19,16 @@ type (
 
 type RedisCli struct {
 	*redis.Client
+
+	stats   *redisStats
+	tracer  Tracer
+	breaker *breaker
+}
+
+// observe records a call to Redis that started at start.
+func (r *RedisCli) observe(op redisOp, start time.Time, err error) {
+	r.stats.observe(op, start, err)
+	r.breaker.done(time.Since(start), err)
 }
 
 func (r *RedisCli) mget(ctx context.Context, keys []string, opt options) (map[string]interface{}, error) {
@@ -25,18 +36,24 @@ func (r *RedisCli) mget(ctx context.Context, keys []string, opt options) (map[st
 	if r.Client == nil {
 		return res, RedisNotFoundError
 	}
+	if !r.breaker.allow() {
+		return res, CircuitOpenError
+	}
 
 	var (
+		err       error
 		pipelined int
 		cmders    = make([]*redis.StringCmd, 0, len(keys))
 		pipe      = r.Pipeline()
+		start     = time.Now()
+		sp        span
 	)
 	defer pipe.Close()
+	ctx, sp = startSpan(ctx, r.tracer, "mget", TierRedis, len(keys))
 
 	for _, key := range keys {
 		if pipelined > maxBatchExecLength {
-			if _, err := pipe.Exec(ctx); err != nil {
-
+			if _, err = pipe.Exec(ctx); err != nil && err != redis.Nil {
 				goto RESULT
 			} else {
 				pipelined = 0
@@ -47,11 +64,10 @@ func (r *RedisCli) mget(ctx context.Context, keys []string, opt options) (map[st
 		pipelined++
 	}
 
-	if _, err := pipe.Exec(ctx); err != nil {
-
-	}
+	_, err = pipe.Exec(ctx)
 
 RESULT:
+	r.observe(redisMGet, start, err)
 	for index, cmder := range cmders {
 		reply, err := cmder.Bytes()
 		if err != nil {
@@ -67,6 +83,7 @@ RESULT:
 			res[keys[index]] = reply
 		}
 	}
+	sp.end(mgetOutcome(len(res), len(keys), err), err)
 
 	return res, nil
 }
@@ -75,8 +92,16 @@ func (r *RedisCli) get(ctx context.Context, key string, opt options) (interface{
 	if r.Client == nil {
 		return nil, RedisNotFoundError
 	}
+	if !r.breaker.allow() {
+		return nil, KeyNotFoundError
+	}
 
-	if v, err := r.Get(ctx, key).Bytes(); err == nil && len(v) > 0 {
+	start := time.Now()
+	ctx, sp := startSpan(ctx, r.tracer, "get", TierRedis, 1)
+	v, err := r.Get(ctx, key).Bytes()
+	r.observe(redisGet, start, err)
+	sp.end(readOutcome(err), err)
+	if err == nil && len(v) > 0 {
 		if opt.deserializeFunc != nil {
 			val, err := opt.deserializeFunc(ctx, v)
 			if err != nil {
@@ -109,13 +134,23 @@ func (r *RedisCli) mset(ctx context.Context, keys []string, values []interface{}
 	if len(keys) != len(values) {
 		return KeyValueLenError
 	}
+	if !r.breaker.allow() {
+		return CircuitOpenError
+	}
 
 	var (
 		err       error
 		pipelined int
 		pipe      = r.Pipeline()
+		start     = time.Now()
+		sp        span
 	)
 	defer pipe.Close()
+	ctx, sp = startSpan(ctx, r.tracer, "mset", TierRedis, len(keys))
+	defer func() {
+		r.observe(redisMSet, start, err)
+		sp.end(writeOutcome(err), err)
+	}()
 
 	for i, key := range keys {
 		var val interface{}
@@ -130,26 +165,67 @@ func (r *RedisCli) mset(ctx context.Context, keys []string, values []interface{}
 		}
 
 		if pipelined > maxBatchExecLength {
-			if _, err := pipe.Exec(ctx); err != nil {
+			if _, err = pipe.Exec(ctx); err != nil {
 				return err
 			} else {
 				pipelined = 0
 			}
 		}
 
-		if opt.TTL == 0 {
+		if ttl := opt.ttlOf(key); ttl == 0 {
 			pipe.Set(ctx, key, val, 0)
 		} else {
-			pipe.SetEX(ctx, key, val, opt.TTL)
+			pipe.SetEX(ctx, key, val, ttl)
 		}
 		pipelined++
 	}
 
-	if _, err := pipe.Exec(ctx); err != nil {
-		return err
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// setBatch writes already encoded values, each with its own TTL, in
+// pipelines.
+func (r *RedisCli) setBatch(ctx context.Context, keys []string, values []interface{}, ttls []time.Duration) error {
+	if r.Client == nil {
+		return RedisNotFoundError
+	}
+	if !r.breaker.allow() {
+		return CircuitOpenError
 	}
 
-	return nil
+	var (
+		err       error
+		pipelined int
+		pipe      = r.Pipeline()
+		start     = time.Now()
+		sp        span
+	)
+	defer pipe.Close()
+	ctx, sp = startSpan(ctx, r.tracer, "mset", TierRedis, len(keys))
+	defer func() {
+		r.observe(redisMSet, start, err)
+		sp.end(writeOutcome(err), err)
+	}()
+
+	for i, key := range keys {
+		if pipelined > maxBatchExecLength {
+			if _, err = pipe.Exec(ctx); err != nil {
+				return err
+			}
+			pipelined = 0
+		}
+
+		if ttls[i] == 0 {
+			pipe.Set(ctx, key, values[i], 0)
+		} else {
+			pipe.SetEX(ctx, key, values[i], ttls[i])
+		}
+		pipelined++
+	}
+
+	_, err = pipe.Exec(ctx)
+	return err
 }
 
 func (r *RedisCli) set(ctx context.Context, key string, val interface{}, opt options) error {
@@ -166,52 +242,117 @@ func (r *RedisCli) set(ctx context.Context, key string, val interface{}, opt opt
 			return err
 		}
 	}
+	if !r.breaker.allow() {
+		return CircuitOpenError
+	}
 
-	if opt.TTL == 0 {
+	start := time.Now()
+	ctx, sp := startSpan(ctx, r.tracer, "set", TierRedis, 1)
+	if ttl := opt.ttlOf(key); ttl == 0 {
 		err = r.Set(ctx, key, val, 0).Err()
 	} else {
-		err = r.SetEX(ctx, key, val, opt.TTL).Err()
+		err = r.SetEX(ctx, key, val, ttl).Err()
 	}
+	r.observe(redisSet, start, err)
+	sp.end(writeOutcome(err), err)
 	return err
 }
 
-func (r *RedisCli) del(ctx context.Context, key string) error {
+// acquire takes the lease key with token for ttl, unless another token holds
+// it.
+func (r *RedisCli) acquire(ctx context.Context, key, token string, ttl time.Duration) (bool, error) {
 	if r.Client == nil {
-		return RedisNotFoundError
+		return false, RedisNotFoundError
+	}
+	if !r.breaker.allow() {
+		return false, CircuitOpenError
 	}
 
-	return r.Del(ctx, key).Err()
+	start := time.Now()
+	ctx, sp := startSpan(ctx, r.tracer, "lease", TierRedis, 1)
+	ok, err := r.SetNX(ctx, key, token, ttl).Result()
+	r.observe(redisLease, start, err)
+	sp.end(writeOutcome(err), err)
+	return ok, err
 }
 
-func (r *RedisCli) mdel(ctx context.Context, keys []string) error {
+// release deletes the lease key if token still holds it.
+func (r *RedisCli) release(ctx context.Context, key, token string) error {
 	if r.Client == nil {
 		return RedisNotFoundError
 	}
+	if !r.breaker.allow() {
+		return CircuitOpenError
+	}
+
+	start := time.Now()
+	ctx, sp := startSpan(ctx, r.tracer, "release", TierRedis, 1)
+	err := releaseScript.Run(ctx, r.Client, []string{key}, token).Err()
+	r.observe(redisRelease, start, err)
+	sp.end(writeOutcome(err), err)
+	return err
+}
+
+// del deletes key and reports whether it was present.
+func (r *RedisCli) del(ctx context.Context, key string) (bool, error) {
+	if r.Client == nil {
+		return false, RedisNotFoundError
+	}
+	if !r.breaker.allow() {
+		return false, CircuitOpenError
+	}
+
+	start := time.Now()
+	ctx, sp := startSpan(ctx, r.tracer, "del", TierRedis, 1)
+	n, err := r.Del(ctx, key).Result()
+	r.observe(redisDel, start, err)
+	sp.end(writeOutcome(err), err)
+	return n > 0, err
+}
+
+// mdel deletes keys and reports which ones were present.
+func (r *RedisCli) mdel(ctx context.Context, keys []string) ([]bool, error) {
+	deleted := make([]bool, len(keys))
+	if r.Client == nil {
+		return deleted, RedisNotFoundError
+	}
+	if !r.breaker.allow() {
+		return deleted, CircuitOpenError
+	}
 
 	var (
+		err       error
 		pipelined int
+		cmders    = make([]*redis.IntCmd, 0, len(keys))
 		pipe      = r.Pipeline()
+		start     = time.Now()
+		sp        span
 	)
 	defer pipe.Close()
+	ctx, sp = startSpan(ctx, r.tracer, "mdel", TierRedis, len(keys))
+	defer func() {
+		r.observe(redisMDel, start, err)
+		sp.end(writeOutcome(err), err)
+	}()
 
 	for _, key := range keys {
 		if pipelined > maxBatchExecLength {
-			if _, err := pipe.Exec(ctx); err != nil {
-				return err
+			if _, err = pipe.Exec(ctx); err != nil {
+				return deleted, err
 			} else {
 				pipelined = 0
 			}
 		}
 
-		pipe.Del(ctx, key)
+		cmders = append(cmders, pipe.Del(ctx, key))
 		pipelined++
 	}
 
-	if _, err := pipe.Exec(ctx); err != nil {
-		return err
+	_, err = pipe.Exec(ctx)
+	for i, cmder := range cmders {
+		deleted[i] = cmder.Val() > 0
 	}
-
-	return nil
+	return deleted, err
 }
 
 type dataWrapper interface {
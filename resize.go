@@ -0,0 +1,276 @@
+package mcache
+
+import (
+	"context"
+	"time"
+)
+
+const migrateBatchSize = 1 << 7 //每批迁移的条目数
+
+// shardTable is the set of local shards, Resize replaces it as a whole. While
+// resharding, prev holds the shards whose entries are being migrated.
+type shardTable[T any, P CachePolicy[T]] struct {
+	shards   []P
+	size     int
+	maxBytes int64
+	prev     *shardTable[T, P]
+}
+
+func (c *cacheHandler[T, P]) newTable(size int, maxBytes int64, count int) *shardTable[T, P] {
+	t := &shardTable[T, P]{
+		shards:   make([]P, count),
+		size:     size,
+		maxBytes: maxBytes,
+	}
+	for i := 0; i < count; i++ {
+		var p = P(new(T))
+		p.Init(c.clock, splitCapacity(size, count, i), c.policyOptions(splitMaxBytes(maxBytes, count, i))...)
+		t.shards[i] = p
+	}
+	return t
+}
+
+func (c *cacheHandler[T, P]) loadTable() *shardTable[T, P] {
+	return c.table.Load().(*shardTable[T, P])
+}
+
+func (t *shardTable[T, P]) shard(hasher Hasher, key string) P {
+	return t.shards[hasher(key)&uint64(len(t.shards)-1)]
+}
+
+// Resize changes the capacity of the local shards to size, evicting per policy
+// when shrinking. WithMaxBytes changes the byte budget and WithShardCount the
+// number of shards, any other option is ignored. Changing the number of shards
+// migrates the entries to the new shards batch by batch: reads go on
+// uninterrupted and writes wait for at most one batch. If ctx is done before
+// the migration completes, the entries not migrated yet are dropped.
+func (c *cacheHandler[T, P]) Resize(ctx context.Context, size int, opts ...Option) error {
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	c.resizeMu.Lock()
+	defer c.resizeMu.Unlock()
+
+	cur := c.loadTable()
+	if size <= 0 {
+		size = cur.size
+	}
+	maxBytes := cur.maxBytes
+	if o.MaxBytes > 0 {
+		maxBytes = o.MaxBytes
+	}
+	count := len(cur.shards)
+	if o.ShardCount > 0 {
+		count = o.ShardCount
+	}
+	count = shardCount(size, count)
+
+	if count == len(cur.shards) {
+		for i, s := range cur.shards {
+			s.Resize(ctx, splitCapacity(size, count, i), splitMaxBytes(maxBytes, count, i))
+		}
+		c.table.Store(&shardTable[T, P]{shards: cur.shards, size: size, maxBytes: maxBytes})
+		return nil
+	}
+
+	next := c.newTable(size, maxBytes, count)
+	next.prev = cur
+	c.migrateMu.Lock()
+	c.table.Store(next)
+	c.migrateMu.Unlock()
+
+	err := c.migrate(ctx, next)
+
+	c.migrateMu.Lock()
+	c.table.Store(&shardTable[T, P]{shards: next.shards, size: size, maxBytes: maxBytes})
+	c.migrateMu.Unlock()
+	c.retire(ctx, cur)
+	return err
+}
+
+// retire keeps the counters of shards replaced by a reshard in the cache
+// totals. The entries left behind by a canceled migration are dropped as
+// EvictCapacity.
+func (c *cacheHandler[T, P]) retire(ctx context.Context, t *shardTable[T, P]) {
+	var dropped []Entry
+	for _, s := range t.shards {
+		st := s.Stats()
+		c.retired.Hits += st.Hits
+		c.retired.Misses += st.Misses
+		c.retired.Evictions.add(st.Evictions)
+		c.retired.Evictions.Capacity += uint64(st.Size)
+		if c.evictHook != nil && st.Size > 0 {
+			s.Range(ctx, func(e Entry) bool {
+				dropped = append(dropped, e)
+				return true
+			})
+		}
+	}
+
+	for _, e := range dropped {
+		c.evictHook(e, EvictCapacity)
+	}
+}
+
+// migrate moves the entries of next.prev to next, hottest first so the hottest
+// entries survive when next is smaller: the entries a full shard refuses are
+// dropped as EvictCapacity.
+func (c *cacheHandler[T, P]) migrate(ctx context.Context, next *shardTable[T, P]) error {
+	var (
+		batch   = make([]Entry, 0, migrateBatchSize)
+		dropped []Entry
+	)
+	for _, old := range next.prev.shards {
+		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			batch = batch[:0]
+			c.migrateMu.Lock()
+			old.Range(ctx, func(e Entry) bool {
+				batch = append(batch, e)
+				return len(batch) < migrateBatchSize
+			})
+			dropped = dropped[:0]
+			for _, e := range batch {
+				if err := next.shard(c.hasher, e.Key).Insert(ctx, e); err != nil {
+					dropped = append(dropped, e)
+				}
+				old.Remove(ctx, e.Key, evictMigrated)
+			}
+			c.migrateMu.Unlock()
+
+			c.retired.Evictions.Capacity += uint64(len(dropped))
+			if c.evictHook != nil {
+				for _, e := range dropped {
+					c.evictHook(e, EvictCapacity)
+				}
+			}
+
+			if len(batch) == 0 {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// localGet looks key up in the local shards. While resharding the shards being
+// migrated from are looked up first: an entry is inserted into its new shard
+// before it leaves the old one, so the other order could miss it in both. A
+// miss is retried if a reshard started meanwhile.
+func (c *cacheHandler[T, P]) localGet(ctx context.Context, key string) (interface{}, error) {
+	for {
+		t := c.loadTable()
+		val, err := t.get(ctx, c.hasher, key)
+		if err == nil || c.loadTable() == t {
+			return val, err
+		}
+	}
+}
+
+func (c *cacheHandler[T, P]) localExists(ctx context.Context, key string) bool {
+	for {
+		t := c.loadTable()
+		if t.exists(ctx, c.hasher, key) {
+			return true
+		}
+		if c.loadTable() == t {
+			return false
+		}
+	}
+}
+
+// localDeadline returns the deadline and load time of the live local entry of
+// key, looked up like localGet.
+func (c *cacheHandler[T, P]) localDeadline(ctx context.Context, key string) (time.Time, time.Duration, bool) {
+	for {
+		t := c.loadTable()
+		deadline, loadTime, ok := t.deadline(ctx, c.hasher, key)
+		if ok || c.loadTable() == t {
+			return deadline, loadTime, ok
+		}
+	}
+}
+
+func (c *cacheHandler[T, P]) localTTL(ctx context.Context, key string) (time.Duration, bool) {
+	deadline, _, ok := c.localDeadline(ctx, key)
+	if !ok || deadline.IsZero() {
+		return 0, ok
+	}
+	ttl := deadline.Sub(c.clock.Now())
+	return ttl, ttl > 0
+}
+
+func (t *shardTable[T, P]) get(ctx context.Context, hasher Hasher, key string) (interface{}, error) {
+	if t.prev != nil {
+		if val, err := t.prev.shard(hasher, key).Get(ctx, key); err == nil {
+			return val, nil
+		}
+	}
+	return t.shard(hasher, key).Get(ctx, key)
+}
+
+func (t *shardTable[T, P]) deadline(ctx context.Context, hasher Hasher, key string) (time.Time, time.Duration, bool) {
+	if t.prev != nil {
+		if deadline, loadTime, ok := t.prev.shard(hasher, key).Deadline(ctx, key); ok {
+			return deadline, loadTime, true
+		}
+	}
+	return t.shard(hasher, key).Deadline(ctx, key)
+}
+
+func (t *shardTable[T, P]) exists(ctx context.Context, hasher Hasher, key string) bool {
+	if t.prev != nil && t.prev.shard(hasher, key).Exists(ctx, key) {
+		return true
+	}
+	return t.shard(hasher, key).Exists(ctx, key)
+}
+
+// localSet stores key in the local shards, dropping any stale copy from the
+// shards being migrated from.
+func (c *cacheHandler[T, P]) localSet(ctx context.Context, key string, val interface{}, ttl time.Duration) error {
+	c.migrateMu.RLock()
+	defer c.migrateMu.RUnlock()
+
+	t := c.loadTable()
+	if t.prev != nil {
+		t.prev.shard(c.hasher, key).Remove(ctx, key, EvictReplaced)
+	}
+	return t.shard(c.hasher, key).Set(ctx, key, val, ttl)
+}
+
+// localSetLoadTime records how long the value of key, just set, took to load.
+func (c *cacheHandler[T, P]) localSetLoadTime(ctx context.Context, key string, d time.Duration) {
+	c.migrateMu.RLock()
+	defer c.migrateMu.RUnlock()
+
+	c.loadTable().shard(c.hasher, key).SetLoadTime(ctx, key, d)
+}
+
+// localInsert adds e to the local shards unless its key is present.
+func (c *cacheHandler[T, P]) localInsert(ctx context.Context, e Entry) error {
+	c.migrateMu.RLock()
+	defer c.migrateMu.RUnlock()
+
+	t := c.loadTable()
+	if t.prev != nil && t.prev.shard(c.hasher, e.Key).Exists(ctx, e.Key) {
+		return nil
+	}
+	return t.shard(c.hasher, e.Key).Insert(ctx, e)
+}
+
+func (c *cacheHandler[T, P]) localRemove(ctx context.Context, key string, reason EvictReason) bool {
+	c.migrateMu.RLock()
+	defer c.migrateMu.RUnlock()
+
+	t := c.loadTable()
+	ok := t.shard(c.hasher, key).Remove(ctx, key, reason)
+	if t.prev != nil && t.prev.shard(c.hasher, key).Remove(ctx, key, reason) {
+		ok = true
+	}
+	return ok
+}
@@ -0,0 +1,168 @@
+package mcache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResize(t *testing.T) {
+	t.Run("simple cache", runResize[SimpleCache])
+	t.Run("lfu cache", runResize[LfuCache])
+	t.Run("lru cache", runResize[LruCache])
+	t.Run("arc cache", runResize[ArcCache])
+	t.Run("adaptive cache", runResize[AdaptiveCache])
+}
+
+func runResize[T any, P CachePolicy[T]](t *testing.T) {
+	var (
+		ctx = context.TODO()
+		cc  = New[T, P](256, WithShardCount(4)).(*cacheHandler[T, P])
+	)
+	defer cc.Close()
+
+	for i := 0; i < 256; i++ {
+		assert.Nil(t, cc.Set(ctx, fmt.Sprint(i), i))
+	}
+
+	assert.Nil(t, cc.Resize(ctx, 128))
+	assert.Equal(t, 4, len(cc.loadTable().shards))
+	assert.LessOrEqual(t, countKeys(ctx, cc, 256), 128)
+
+	kept := make(map[string]interface{})
+	for i := 0; i < 256; i++ {
+		if val, err := cc.Get(ctx, fmt.Sprint(i)); err == nil {
+			kept[fmt.Sprint(i)] = val
+		}
+	}
+
+	assert.Nil(t, cc.Resize(ctx, 4096, WithShardCount(16)))
+	assert.Equal(t, 16, len(cc.loadTable().shards))
+	assert.Nil(t, cc.loadTable().prev)
+	for key, val := range kept {
+		got, err := cc.Get(ctx, key)
+		assert.Nil(t, err)
+		assert.Equal(t, val, got)
+	}
+
+	for i := 0; i < 512; i++ {
+		assert.Nil(t, cc.Set(ctx, fmt.Sprint(i), i))
+	}
+	assert.Equal(t, 512, countKeys(ctx, cc, 512))
+
+	assert.Nil(t, cc.Resize(ctx, 64, WithShardCount(2)))
+	assert.Equal(t, 2, len(cc.loadTable().shards))
+	assert.LessOrEqual(t, countKeys(ctx, cc, 512), 64)
+}
+
+func TestResizeConcurrentReads(t *testing.T) {
+	var (
+		ctx = context.TODO()
+		cc  = New[LruCache](8192, WithShardCount(2)).(*cacheHandler[LruCache, *LruCache])
+	)
+	defer cc.Close()
+
+	for i := 0; i < 512; i++ {
+		assert.Nil(t, cc.Set(ctx, fmt.Sprint(i), i))
+	}
+
+	var (
+		wg     sync.WaitGroup
+		stop   = make(chan struct{})
+		misses = make([]int, 4)
+	)
+	for r := 0; r < len(misses); r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if _, err := cc.Get(ctx, fmt.Sprint(i%512)); err != nil {
+					misses[r]++
+				}
+			}
+		}(r)
+	}
+
+	for _, count := range []int{16, 4, 32, 1} {
+		assert.Nil(t, cc.Resize(ctx, 8192, WithShardCount(count)))
+		assert.Equal(t, count, len(cc.loadTable().shards))
+	}
+	close(stop)
+	wg.Wait()
+
+	assert.Equal(t, make([]int, 4), misses)
+	assert.Equal(t, 512, countKeys(ctx, cc, 512))
+}
+
+func TestResizeCanceled(t *testing.T) {
+	dropped := 0
+	onEvict := func(key string, value interface{}, reason EvictReason) {
+		assert.Equal(t, EvictCapacity, reason)
+		dropped++
+	}
+	cc := New[LruCache](1024, WithShardCount(2), WithOnEvict(onEvict)).(*cacheHandler[LruCache, *LruCache])
+	defer cc.Close()
+
+	for i := 0; i < 512; i++ {
+		assert.Nil(t, cc.Set(context.TODO(), fmt.Sprint(i), i))
+	}
+	assert.Nil(t, cc.Resize(context.TODO(), 1024, WithShardCount(4)))
+	assert.Equal(t, 0, dropped)
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	assert.Equal(t, context.Canceled, cc.Resize(ctx, 1024, WithShardCount(8)))
+	assert.Equal(t, 512, dropped)
+	assert.Equal(t, 8, len(cc.loadTable().shards))
+	assert.Nil(t, cc.loadTable().prev)
+	assert.Nil(t, cc.Set(context.TODO(), "k", "v"))
+	assert.True(t, cc.Exists(context.TODO(), "k"))
+}
+
+func TestResizeIntoFullShard(t *testing.T) {
+	var (
+		ctx     = context.TODO()
+		dropped []string
+		onEvict = func(key string, value interface{}, reason EvictReason) {
+			assert.Equal(t, EvictCapacity, reason)
+			dropped = append(dropped, key)
+		}
+		// a single old shard keeps the migration order hottest first.
+		hasher = func(string) uint64 { return 0 }
+		cc     = New[LruCache](16, WithShardCount(2), WithHasher(hasher), WithOnEvict(onEvict)).(*cacheHandler[LruCache, *LruCache])
+	)
+	defer cc.Close()
+
+	for i := 0; i < 8; i++ {
+		assert.Nil(t, cc.Set(ctx, fmt.Sprint(i), i))
+	}
+	_, err := cc.Get(ctx, "0")
+	assert.Nil(t, err)
+
+	// the new shard fills up with the hottest entries and refuses the rest.
+	assert.Nil(t, cc.Resize(ctx, 4, WithShardCount(1)))
+	for _, key := range []string{"0", "7", "6", "5"} {
+		assert.True(t, cc.Exists(ctx, key), key)
+	}
+	assert.Equal(t, 4, countKeys(ctx, cc, 8))
+	assert.ElementsMatch(t, []string{"1", "2", "3", "4"}, dropped)
+	assert.Equal(t, uint64(4), cc.Stats().Evictions.Capacity)
+}
+
+func countKeys[T any, P CachePolicy[T]](ctx context.Context, cc *cacheHandler[T, P], n int) int {
+	count := 0
+	for i := 0; i < n; i++ {
+		if cc.Exists(ctx, fmt.Sprint(i)) {
+			count++
+		}
+	}
+	return count
+}
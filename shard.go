@@ -3,55 +3,210 @@ package mcache
 import (
 	"context"
 	"errors"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type CachePolicy[T any] interface {
-	Init(clock Clock, capacity int)
+	Init(clock Clock, capacity int, opts ...PolicyOption)
 	Set(ctx context.Context, key string, val interface{}, ttl time.Duration) error
 	Get(ctx context.Context, key string) (interface{}, error)
 	Exists(ctx context.Context, key string) bool
-	Remove(ctx context.Context, key string) bool
+	// Deadline returns the deadline of the live entry of key, zero without a
+	// TTL, the load time recorded by SetLoadTime, and whether key is live.
+	Deadline(ctx context.Context, key string) (time.Time, time.Duration, bool)
+	// SetLoadTime records how long the value of key took to load, it is kept
+	// until the entry is removed.
+	SetLoadTime(ctx context.Context, key string, d time.Duration)
+	// Remove removes key, reporting it to the listener with reason.
+	Remove(ctx context.Context, key string, reason EvictReason) bool
 	Evict(ctx context.Context, count int)
+	// Sweep removes every expired entry and returns how many were removed.
+	Sweep(ctx context.Context) int
+	// Resize changes the capacity and the byte budget, evicting until the
+	// entries fit.
+	Resize(ctx context.Context, capacity int, maxBytes int64)
+	// Range calls fn for every live entry, hottest first, until fn returns
+	// false. fn must not call back into the policy.
+	Range(ctx context.Context, fn func(Entry) bool)
+	// Insert adds e as the coldest entry unless its key is present, keeping
+	// its deadline and frequency. Only expired entries make room for it: a
+	// shard full of live entries refuses it with ShardFullError.
+	Insert(ctx context.Context, e Entry) error
+	// Stats returns the counters of the shard.
+	Stats() ShardStats
 
 	*T
 }
 
+// Entry is a live entry of a policy, as walked by Range and added by Insert.
+type Entry struct {
+	Key   string
+	Value interface{}
+	// ExpireAt is zero for entries without a TTL.
+	ExpireAt time.Time
+	// Freq is the access frequency tracked by the policy, if any.
+	Freq uint64
+	// LoadTime is how long the value took to load, if recorded.
+	LoadTime time.Duration
+}
+
+type PolicyOption func(*policyOptions)
+
+type policyOptions struct {
+	MaxBytes int64
+	Sizer    Sizer
+	LfuAging LfuAging
+	OnEvict  func(Entry, EvictReason)
+
+	SweepInterval time.Duration
+}
+
+func WithPolicyMaxBytes(maxBytes int64) PolicyOption {
+	return func(o *policyOptions) {
+		o.MaxBytes = maxBytes
+	}
+}
+
+func WithPolicySizer(sizer Sizer) PolicyOption {
+	return func(o *policyOptions) {
+		o.Sizer = sizer
+	}
+}
+
+// WithPolicyLfuAging configures frequency aging, it is ignored by every
+// policy but LfuCache.
+func WithPolicyLfuAging(aging LfuAging) PolicyOption {
+	return func(o *policyOptions) {
+		o.LfuAging = aging
+	}
+}
+
+// WithPolicyOnEvict calls fn with every entry leaving the shard, after the
+// shard lock is released.
+func WithPolicyOnEvict(fn OnEvictFunc) PolicyOption {
+	return func(o *policyOptions) {
+		o.OnEvict = func(e Entry, reason EvictReason) {
+			fn(e.Key, e.Value, reason)
+		}
+	}
+}
+
+// WithPolicySweep indexes deadlines in a timing wheel ticking every interval,
+// for shards swept in the background.
+func WithPolicySweep(interval time.Duration) PolicyOption {
+	return func(o *policyOptions) {
+		o.SweepInterval = interval
+	}
+}
+
+func newPolicyOptions(opts ...PolicyOption) policyOptions {
+	o := policyOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
 type cacheHandler[T any, P CachePolicy[T]] struct {
 	cache
 
-	shards []P
+	table     atomic.Value // *shardTable[T, P]
+	resizeMu  sync.Mutex
+	migrateMu sync.RWMutex
+	retired   ShardStats // counters of the shards dropped by Resize, guarded by resizeMu
+
+	bgMu    sync.Mutex // guards closed and the background timers
+	closed  bool
+	janitor Timer
 }
 
 func newCacheHandler[T any, P CachePolicy[T]](b builder[T, P]) Cache {
 	c := &cacheHandler[T, P]{}
 	c.cache = b.cache
+	if c.onEvict != nil || c.disk != nil {
+		c.evictHook = c.evicted
+	}
+	c.table.Store(c.newTable(c.size, c.maxBytes, c.shardCount))
 
-	c.shards = make([]P, c.shardCount)
-	for i := 0; i < c.shardCount; i++ {
-		var p = P(new(T))
-		p.Init(c.clock, c.shardCap)
-		c.shards[i] = p
+	if c.sweepInterval > 0 {
+		c.bgMu.Lock()
+		c.janitor = c.clock.AfterFunc(c.sweepInterval, c.janitorTick)
+		c.bgMu.Unlock()
 	}
 
 	return c
 }
 
-func (c cacheHandler[T, P]) Set(ctx context.Context, key string, value interface{}, opts ...Option) error {
+// janitorTick sweeps expired entries off every shard and re-arms the janitor,
+// until the cache is closed.
+func (c *cacheHandler[T, P]) janitorTick() {
+	c.bgMu.Lock()
+	defer c.bgMu.Unlock()
+
+	if c.closed {
+		return
+	}
+	c.sweep(context.Background())
+	c.janitor.Reset(c.sweepInterval)
+}
+
+func (c *cacheHandler[T, P]) sweep(ctx context.Context) int {
+	n := 0
+	for _, s := range c.loadTable().shards {
+		n += s.Sweep(ctx)
+	}
+	return n
+}
+
+// Close stops the background work of the cache, waiting for a sweep in
+// progress. It flushes the writes queued by WithWriteBehind, then runs the
+// second deletes and retries the failed deletes of Invalidate. It is safe to
+// call Close more than once.
+func (c *cacheHandler[T, P]) Close() error {
+	c.bgMu.Lock()
+	defer c.bgMu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	if c.janitor != nil {
+		c.janitor.Stop()
+	}
+	var err error
+	if c.writeBehind != nil {
+		err = c.writeBehind.close(context.Background())
+	}
+	if ierr := c.invalidator.close(context.Background()); err == nil {
+		err = ierr
+	}
+	return err
+}
+
+func (c *cacheHandler[T, P]) Set(ctx context.Context, key string, value interface{}, opts ...Option) error {
+	ctx, sp := startSpan(ctx, c.tracer, "set", TierCache, 1)
+	err := c.set(ctx, key, value, opts...)
+	sp.end(writeOutcome(err), err)
+	return err
+}
+
+func (c *cacheHandler[T, P]) set(ctx context.Context, key string, value interface{}, opts ...Option) error {
 	o := c.getOption(opts...)
 	defer c.putOpt(o)
 
-	if c.redisCli.Client != nil {
-		err := c.redisCli.set(ctx, key, value, o)
-		if err != nil {
-			return err
-		}
-	}
+	return c.write(ctx, []string{key}, []interface{}{value}, o)
+}
 
-	return c.getShard(key).Set(ctx, key, value, o.TTL)
+func (c *cacheHandler[T, P]) MSet(ctx context.Context, keys []string, values []interface{}, opts ...Option) error {
+	ctx, sp := startSpan(ctx, c.tracer, "mset", TierCache, len(keys))
+	err := c.mset(ctx, keys, values, opts...)
+	sp.end(writeOutcome(err), err)
+	return err
 }
 
-func (c cacheHandler[T, P]) MSet(ctx context.Context, keys []string, values []interface{}, opts ...Option) error {
+func (c *cacheHandler[T, P]) mset(ctx context.Context, keys []string, values []interface{}, opts ...Option) error {
 	if len(keys) != len(values) {
 		return KeyValueLenError
 	}
@@ -59,69 +214,161 @@ func (c cacheHandler[T, P]) MSet(ctx context.Context, keys []string, values []in
 	o := c.getOption(opts...)
 	defer c.putOpt(o)
 
-	if c.redisCli.Client != nil {
-		err := c.redisCli.mset(ctx, keys, values, o)
-		if err != nil {
+	return c.write(ctx, keys, values, o)
+}
+
+// write writes keys to the tiers selected by the WriteMode of o.
+func (c *cacheHandler[T, P]) write(ctx context.Context, keys []string, values []interface{}, o options) error {
+	if c.redisCli.Client == nil || o.WriteMode == WriteLocal {
+		return c.localWrite(ctx, keys, values, o)
+	}
+
+	switch o.WriteMode {
+	case WriteAround:
+		c.writeBehind.cancel(c.remoteKeys(keys))
+		if err := c.remoteWrite(ctx, keys, values, o); errors.Is(err, CircuitOpenError) {
+			return c.localWrite(ctx, keys, values, o)
+		} else if err != nil {
 			return err
 		}
+		for _, key := range keys {
+			c.diskRemove(ctx, key)
+			c.localRemove(ctx, key, EvictReplaced)
+		}
+		return nil
+	case WriteBestEffort:
+		if err := c.localWrite(ctx, keys, values, o); err != nil {
+			return err
+		}
+		if queued, err := c.writeBehind.enqueue(ctx, keys, values, o); !queued && err == nil {
+			c.remoteWrite(ctx, keys, values, o)
+		}
+		return nil
+	default:
+		queued, err := c.writeBehind.enqueue(ctx, keys, values, o)
+		if !queued && err == nil {
+			err = c.remoteWrite(ctx, keys, values, o)
+		}
+		if err != nil && !errors.Is(err, CircuitOpenError) {
+			return err
+		}
+		return c.localWrite(ctx, keys, values, o)
 	}
+}
 
-	for i, k := range keys {
-		if err := c.getShard(k).Set(ctx, k, values[i], o.TTL); err != nil {
+// remoteWrite writes keys and their stale copies to Redis. While the circuit
+// is open it fails with CircuitOpenError, the keys are then deleted from Redis
+// once it closes.
+func (c *cacheHandler[T, P]) remoteWrite(ctx context.Context, keys []string, values []interface{}, o options) (err error) {
+	if len(keys) == 1 {
+		err = c.redisCli.set(ctx, keys[0], values[0], o)
+	} else {
+		err = c.redisCli.mset(ctx, keys, values, o)
+	}
+	if err == nil {
+		c.writeStale(ctx, keys, values, o)
+	}
+	if errors.Is(err, CircuitOpenError) {
+		c.redisCli.breaker.invalidate(c.remoteKeys(keys))
+	}
+	return err
+}
+
+func (c *cacheHandler[T, P]) localWrite(ctx context.Context, keys []string, values []interface{}, o options) error {
+	for i, key := range keys {
+		c.diskRemove(ctx, key)
+		if err := c.localSet(ctx, key, values[i], o.ttlOf(key)); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (c cacheHandler[T, P]) Get(ctx context.Context, key string, opts ...Option) (interface{}, error) {
+// Get returns the local value of key. On a miss, whether the key was never
+// set or has expired, the value is loaded and kept locally.
+func (c *cacheHandler[T, P]) Get(ctx context.Context, key string, opts ...Option) (interface{}, error) {
+	ctx, sp := startSpan(ctx, c.tracer, "get", TierCache, 1)
+	val, err := c.get(ctx, key, opts...)
+	sp.end(readOutcome(err), err)
+	return val, err
+}
+
+func (c *cacheHandler[T, P]) get(ctx context.Context, key string, opts ...Option) (interface{}, error) {
 	o := c.getOption(opts...)
 	defer c.putOpt(o)
 
-	s := c.getShard(key)
-
-	val, err := s.Get(ctx, key)
-	if err == KeyNotFoundError {
-		if o.RealLoaderFunc == nil {
-			return nil, KeyNotFoundError
+	val, err := c.localGet(ctx, key)
+	if err == nil {
+		if c.expireEarly(ctx, key, o) {
+			return c.reload(ctx, key, val, o), nil
 		}
+		return val, nil
+	}
+	c.stats.localMiss(1)
+	if val, ok := c.promote(ctx, key); ok {
+		return val, nil
+	}
+	if val, ok := c.writeBehind.lookup(key); ok {
+		c.stats.remoteHit(1)
+		if err := c.localSet(ctx, key, val, o.ttlOf(key)); err != nil && !errors.Is(err, ValueTooLargeError) {
+			return nil, err
+		}
+		return val, nil
+	}
+	if o.RealLoaderFunc == nil {
+		return nil, KeyNotFoundError
+	}
 
-		val, err := o.RealLoaderFunc(ctx, key)
-		if err != nil {
-			if !errors.Is(err, DefaultValueSetError) {
-				return nil, err
-			}
-
-			if errors.Is(err, DefaultValueSetError) {
-				o.TTL = time.Minute
-			}
-
-			if err := s.Set(ctx, key, val, o.TTL); err != nil {
-				return nil, err
-			}
+	val, took, err := o.RealLoaderFunc(ctx, key)
+	if errors.Is(err, StaleValueError) {
+		return val, err
+	}
+	if err != nil && !errors.Is(err, DefaultValueSetError) {
+		return nil, err
+	}
 
-			if errors.Is(err, DefaultValueSetError) {
-				return val, err
-			}
+	ttl := o.ttlOf(key)
+	if err != nil {
+		ttl = time.Minute
+	}
+	if err := c.localSet(ctx, key, val, ttl); err != nil {
+		if !errors.Is(err, ValueTooLargeError) {
+			return nil, err
 		}
+	} else if took > 0 {
+		c.localSetLoadTime(ctx, key, took)
 	}
+	return val, err
+}
 
-	return val, nil
+func (c *cacheHandler[T, P]) MGet(ctx context.Context, keys []string, opts ...Option) (map[string]interface{}, error) {
+	ctx, sp := startSpan(ctx, c.tracer, "mget", TierCache, len(keys))
+	res, err := c.mget(ctx, keys, opts...)
+	sp.end(mgetOutcome(len(res), len(keys), err), err)
+	return res, err
 }
 
-func (c cacheHandler[T, P]) MGet(ctx context.Context, keys []string, opts ...Option) (map[string]interface{}, error) {
+func (c *cacheHandler[T, P]) mget(ctx context.Context, keys []string, opts ...Option) (map[string]interface{}, error) {
 	o := c.getOption(opts...)
 	defer c.putOpt(o)
 
 	res := make(map[string]interface{}, len(keys))
-	miss := make(map[string]P, len(keys))
+	miss := make([]string, 0, len(keys))
 	for _, key := range keys {
-		s := c.getShard(key)
-		val, err := s.Get(ctx, key)
+		val, err := c.localGet(ctx, key)
 		if err == nil {
 			res[key] = val
-		} else if err == KeyNotFoundError {
-			miss[key] = s
+			continue
+		}
+		c.stats.localMiss(1)
+		if val, ok := c.promote(ctx, key); ok {
+			res[key] = val
+		} else if val, ok := c.writeBehind.lookup(key); ok {
+			c.stats.remoteHit(1)
+			c.localSet(ctx, key, val, o.ttlOf(key))
+			res[key] = val
+		} else {
+			miss = append(miss, key)
 		}
 	}
 
@@ -130,19 +377,14 @@ func (c cacheHandler[T, P]) MGet(ctx context.Context, keys []string, opts ...Opt
 			goto END
 		}
 
-		keys := make([]string, 0, len(miss))
-		for key := range miss {
-			keys = append(keys, key)
-		}
-		kvs, err := o.RealMLoaderFunc(ctx, keys)
+		kvs, err := o.RealMLoaderFunc(ctx, miss)
 		if err != nil {
 			goto END
 		}
 
 		for key, val := range kvs {
-			s := miss[key]
-			err := s.Set(ctx, key, val, o.TTL)
-			if err != nil {
+			err := c.localSet(ctx, key, val, o.ttlOf(key))
+			if err != nil && !errors.Is(err, ValueTooLargeError) {
 				goto END
 			}
 
@@ -154,60 +396,204 @@ END:
 	return res, nil
 }
 
-func (c cacheHandler[T, P]) Remove(ctx context.Context, key string) bool {
-	if c.redisCli.Client != nil {
-		err := c.redisCli.del(ctx, key)
-		if err != nil {
-			return false
+// Remove removes key from the tiers selected by the WriteMode, see
+// WithWriteMode. It reports whether key was present in any of them and every
+// delete succeeded, the local copy is removed even if Redis fails.
+func (c *cacheHandler[T, P]) Remove(ctx context.Context, key string, opts ...Option) bool {
+	ctx, sp := startSpan(ctx, c.tracer, "remove", TierCache, 1)
+	ok, err := c.remove(ctx, key, opts...)
+	sp.end(removeOutcome(ok, err), err)
+	return ok
+}
+
+func (c *cacheHandler[T, P]) remove(ctx context.Context, key string, opts ...Option) (bool, error) {
+	o := c.getOption(opts...)
+	defer c.putOpt(o)
+
+	removed, err := c.removeKeys(ctx, []string{key}, o)
+	return removed[0] && err == nil, err
+}
+
+// MRemove removes keys like Remove, it reports whether every key was present
+// and every delete succeeded.
+func (c *cacheHandler[T, P]) MRemove(ctx context.Context, keys []string, opts ...Option) bool {
+	ctx, sp := startSpan(ctx, c.tracer, "mremove", TierCache, len(keys))
+	ok, err := c.mremove(ctx, keys, opts...)
+	sp.end(removeOutcome(ok, err), err)
+	return ok
+}
+
+func (c *cacheHandler[T, P]) mremove(ctx context.Context, keys []string, opts ...Option) (bool, error) {
+	o := c.getOption(opts...)
+	defer c.putOpt(o)
+
+	removed, err := c.removeKeys(ctx, keys, o)
+	if err != nil {
+		return false, err
+	}
+	for _, ok := range removed {
+		if !ok {
+			return false, nil
 		}
 	}
-
-	return c.getShard(key).Remove(ctx, key)
+	return true, nil
 }
 
-func (c cacheHandler[T, P]) MRemove(ctx context.Context, keys []string) bool {
-	if c.redisCli.Client != nil {
-		err := c.redisCli.mdel(ctx, keys)
-		if err != nil {
-			return false
+// removeKeys removes keys from every tier selected by the WriteMode of o and
+// reports which ones were present. A Redis failure doesn't stop the local
+// removals, it is returned unless the mode is WriteBestEffort.
+func (c *cacheHandler[T, P]) removeKeys(ctx context.Context, keys []string, o options) ([]bool, error) {
+	var (
+		removed = make([]bool, len(keys))
+		err     error
+	)
+	if c.redisCli.Client != nil && o.WriteMode != WriteLocal {
+		rkeys := c.remoteKeys(keys)
+		c.writeBehind.cancel(rkeys)
+		if len(rkeys) == 1 {
+			removed[0], err = c.redisCli.del(ctx, rkeys[0])
+		} else {
+			var deleted []bool
+			deleted, err = c.redisCli.mdel(ctx, rkeys)
+			copy(removed, deleted)
+		}
+		if errors.Is(err, CircuitOpenError) {
+			c.redisCli.breaker.invalidate(rkeys)
+			err = nil
+		}
+		if o.WriteMode == WriteBestEffort {
+			err = nil
 		}
 	}
 
-	for _, key := range keys {
-		if !c.getShard(key).Remove(ctx, key) {
-			return false
+	for i, key := range keys {
+		if c.diskRemove(ctx, key) {
+			removed[i] = true
+		}
+		if c.localRemove(ctx, key, EvictExplicit) {
+			removed[i] = true
 		}
 	}
+	return removed, err
+}
 
-	return true
+// evicted is the listener of the local shards.
+func (c *cacheHandler[T, P]) evicted(e Entry, reason EvictReason) {
+	if reason == EvictCapacity && c.disk != nil {
+		c.demote(context.Background(), e)
+	}
+	if c.onEvict != nil {
+		c.onEvict(e.Key, e.Value, reason)
+	}
+}
+
+// demote moves an entry evicted for lack of room to the disk tier.
+func (c *cacheHandler[T, P]) demote(ctx context.Context, e Entry) {
+	if c.serializeFunc == nil {
+		return
+	}
+	data, err := c.serializeFunc(ctx, e.Value)
+	if err != nil {
+		return
+	}
+	c.disk.Set(e.Key, data, e.ExpireAt)
 }
 
-func (c cacheHandler[T, P]) Exists(ctx context.Context, key string) bool {
-	return c.getShard(key).Exists(ctx, key)
+// promote moves key from the disk tier back to the local shards.
+func (c *cacheHandler[T, P]) promote(ctx context.Context, key string) (interface{}, bool) {
+	if c.disk == nil || c.deserializeFunc == nil {
+		return nil, false
+	}
+
+	ctx, sp := startSpan(ctx, c.tracer, "get", TierDisk, 1)
+	data, expireAt, err := c.disk.Get(key)
+	sp.end(readOutcome(err), err)
+	if err != nil {
+		return nil, false
+	}
+	var ttl time.Duration
+	if !expireAt.IsZero() {
+		if ttl = expireAt.Sub(c.clock.Now()); ttl <= 0 {
+			return nil, false
+		}
+	}
+	val, err := c.deserializeFunc(ctx, data)
+	if err != nil {
+		return nil, false
+	}
+
+	c.stats.diskHit(1)
+	if err := c.localSet(ctx, key, val, ttl); err == nil {
+		c.disk.Delete(key)
+	}
+	return val, true
 }
 
-func (c cacheHandler[T, P]) getShard(key string) P {
-	return c.shards[c.DebugShardIndex(key)]
+// diskRemove drops the disk copy of key, it reports whether there was one.
+func (c *cacheHandler[T, P]) diskRemove(ctx context.Context, key string) bool {
+	if c.disk == nil {
+		return false
+	}
+	ok, _ := c.disk.Delete(key)
+	return ok
 }
 
-func (c cacheHandler[T, P]) DebugShardIndex(key string) uint64 {
-	return MemHashString(key) & uint64(c.shardCount-1)
+func (c *cacheHandler[T, P]) Exists(ctx context.Context, key string) bool {
+	return c.localExists(ctx, key)
 }
 
-func (c cacheHandler[T, P]) debugLocalGet(ctx context.Context, key string) (interface{}, error) {
-	val, err := c.getShard(key).Get(ctx, key)
-	if err != nil {
-		return nil, err
+// Stats returns the counters of the cache, with a breakdown per local shard.
+func (c *cacheHandler[T, P]) Stats() Stats {
+	c.resizeMu.Lock()
+	retired := c.retired
+	c.resizeMu.Unlock()
+
+	s := Stats{
+		LocalHits:     retired.Hits,
+		DiskHits:      atomic.LoadUint64(&c.stats.diskHits),
+		RemoteHits:    atomic.LoadUint64(&c.stats.remoteHits),
+		LoadSuccesses: atomic.LoadUint64(&c.stats.loadSuccesses),
+		LoadFailures:  atomic.LoadUint64(&c.stats.loadFailures),
+		LoadLatency:   c.stats.loadLatency.snapshot(),
+		EarlyReloads:  atomic.LoadUint64(&c.stats.earlyReloads),
+		Redis:         c.stats.redis.snapshot(),
+		WriteBehind:   c.writeBehind.stats(),
+		Breaker:       c.redisCli.breaker.stats(),
+		Invalidation:  c.invalidator.stats(),
+		Lease:         c.stats.lease.snapshot(),
+		Evictions:     retired.Evictions,
+	}
+	s.LoadTime = s.LoadLatency.Sum
+
+	shards := c.loadTable().shards
+	s.Shards = make([]ShardStats, len(shards))
+	for i, shard := range shards {
+		s.Shards[i] = shard.Stats()
+		s.LocalHits += s.Shards[i].Hits
+		s.Evictions.add(s.Shards[i].Evictions)
+		s.Size += s.Shards[i].Size
+	}
+
+	s.Hits = s.LocalHits + s.DiskHits + s.RemoteHits
+	if misses := atomic.LoadUint64(&c.stats.localMisses); misses > s.DiskHits+s.RemoteHits {
+		s.Misses = misses - s.DiskHits - s.RemoteHits
 	}
+	return s
+}
+
+func (c *cacheHandler[T, P]) DebugShardIndex(key string) uint64 {
+	return c.hasher(key) & uint64(len(c.loadTable().shards)-1)
+}
 
-	return val, nil
+func (c *cacheHandler[T, P]) debugLocalGet(ctx context.Context, key string) (interface{}, error) {
+	return c.localGet(ctx, key)
 }
 
-func (c cacheHandler[T, P]) debugLocalRemove(ctx context.Context, key string) bool {
-	return c.getShard(key).Remove(ctx, key)
+func (c *cacheHandler[T, P]) debugLocalRemove(ctx context.Context, key string) bool {
+	return c.localRemove(ctx, key, EvictExplicit)
 }
 
-func (c cacheHandler[T, P]) serialize(ctx context.Context, val interface{}, opts ...Option) ([]byte, error) {
+func (c *cacheHandler[T, P]) serialize(ctx context.Context, val interface{}, opts ...Option) ([]byte, error) {
 	o := c.getOption(opts...)
 	defer c.putOpt(o)
 
@@ -221,7 +607,7 @@ func (c cacheHandler[T, P]) serialize(ctx context.Context, val interface{}, opts
 	return nil, SerializeError
 }
 
-func (c cacheHandler[T, P]) deserialize(ctx context.Context, data []byte, opts ...Option) (interface{}, error) {
+func (c *cacheHandler[T, P]) deserialize(ctx context.Context, data []byte, opts ...Option) (interface{}, error) {
 	o := c.getOption(opts...)
 	defer c.putOpt(o)
 
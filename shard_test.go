@@ -1,7 +1,88 @@
 package mcache
 
-import "testing"
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
 
 func TestNewShard(t *testing.T) {
+	for _, tc := range []struct {
+		size, count, expect int
+	}{
+		{size: 1000, count: 16, expect: 16},
+		{size: 1000, count: 10, expect: 16},
+		{size: 1000, count: 1, expect: 1},
+		{size: 5, count: 16, expect: 4},
+		{size: 1, count: 0, expect: 1},
+	} {
+		cc := New[LruCache](tc.size, WithShardCount(tc.count)).(*cacheHandler[LruCache, *LruCache])
+		assert.Equal(t, tc.expect, len(cc.loadTable().shards))
+
+		total := 0
+		for _, s := range cc.loadTable().shards {
+			total += s.cap
+		}
+		assert.Equal(t, tc.size, total)
+	}
+
+	cc := New[LruCache](1 << 16).(*cacheHandler[LruCache, *LruCache])
+	count := len(cc.loadTable().shards)
+	assert.Equal(t, 0, count&(count-1))
+
+	used := make(map[uint64]int, count)
+	for i := 0; i < 100*count; i++ {
+		used[cc.DebugShardIndex(fmt.Sprint(i))]++
+	}
+	assert.Equal(t, count, len(used))
+}
+
+func TestShardHasher(t *testing.T) {
+	for _, hasher := range []Hasher{XXHashString, FnvHashString, XXHashStringSeed(42)} {
+		a := New[LruCache](1024, WithShardCount(16), WithHasher(hasher))
+		b := New[LruCache](1024, WithShardCount(16), WithHasher(hasher))
+		for i := 0; i < 100; i++ {
+			key := fmt.Sprint(i)
+			assert.Equal(t, hasher(key)&15, a.DebugShardIndex(key))
+			assert.Equal(t, a.DebugShardIndex(key), b.DebugShardIndex(key))
+		}
+	}
+
+	// a seed changes the hash, FNV-1a spreads keys differing in any byte.
+	seeded, other := XXHashStringSeed(1), XXHashStringSeed(2)
+	assert.Equal(t, seeded("key"), XXHashStringSeed(1)("key"))
+	assert.NotEqual(t, seeded("key"), other("key"))
+	assert.Equal(t, uint64(0xa9f37ed7), FnvHashString("foo"))
+	used := make(map[uint64]bool)
+	for c := 'a'; c < 'a'+16; c++ {
+		used[FnvHashString(string(c)+"x")&15] = true
+	}
+	assert.Greater(t, len(used), 8)
+
+	cc := New[LruCache](1024, WithShardCount(16), WithHasher(func(string) uint64 { return 7 }))
+	assert.Equal(t, uint64(7), cc.DebugShardIndex("any"))
+}
+
+func TestCacheJanitor(t *testing.T) {
+	var (
+		ctx = context.TODO()
+		fc  = NewFakeClock()
+		cc  = New[LruCache](64, WithShardCount(1), WithClock(fc), WithExpirationSweep(time.Second)).(*cacheHandler[LruCache, *LruCache])
+		s   = cc.loadTable().shards[0]
+	)
+
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, cc.Set(ctx, fmt.Sprint(i), i, WithTTL(time.Duration(i+1)*time.Second)))
+	}
+
+	fc.Advance(6 * time.Second)
+	assert.Equal(t, 5, len(s.items))
 
+	assert.Nil(t, cc.Close())
+	assert.Nil(t, cc.Close())
+	fc.Advance(time.Hour)
+	assert.Equal(t, 5, len(s.items))
 }
@@ -1,194 +1,300 @@
 package mcache
 
 import (
-	"container/heap"
 	"context"
 	"sync"
 	"time"
 )
 
 type SimpleCache struct {
-	clock Clock
-	items map[string]simpleItem
-	pq    simplepq
-	cap   int
-	sync.Mutex
+	clock    Clock
+	items    map[string]*simpleItem
+	expiry   expiryIndex
+	cap      int
+	counters shardCounters
+	listener evictListener
+	weigher
+	sync.RWMutex
 }
 
-func (c *SimpleCache) Init(clock Clock, capacity int) {
+func (c *SimpleCache) Init(clock Clock, capacity int, opts ...PolicyOption) {
 	c.clock = clock
-	c.items = make(map[string]simpleItem, capacity)
-	c.pq = make(simplepq, 0, capacity)
+	c.items = make(map[string]*simpleItem, capacity)
 	c.cap = capacity
+
+	o := newPolicyOptions(opts...)
+	c.expiry = newExpiryIndex(clock, capacity, true, o)
+	c.weigher.init(o)
+	c.listener.init(o)
+	c.counters = shardCounters{}
 }
 
 func (c *SimpleCache) Set(ctx context.Context, key string, val interface{}, ttl time.Duration) error {
 	c.Lock()
-	defer c.Unlock()
+	defer c.listener.unlock(c)
 
 	value := deref(val)
-	var entry = simpleEntry{
-		key: key,
+	cost := c.cost(key, value)
+	if c.tooLarge(cost) {
+		return ValueTooLargeError
 	}
 
 	item, ok := c.items[key]
-	if ttl > 0 {
-		item.expireAt = c.clock.Now().Add(ttl)
-	} else {
-		item.expireAt = c.clock.Now().Add(defaultExpiredAt)
-	}
-
 	if ok {
+		reason := evictReason(c.clock, &item.expiration, EvictReplaced)
+		c.counters.evicted(reason)
+		c.listener.add(&item.expiration, item.value, reason)
+		c.used += cost - item.cost
 		item.value = value
-		entry.priority = item.expireAt.UnixNano()
-		c.pq.update(entry.index)
+		item.cost = cost
+		c.expiry.set(&item.expiration, deadline(c.clock.Now(), ttl), ttl > 0)
+		c.shrink(ctx, item)
 	} else {
-		c.evict(ctx, 1)
-		item.value = value
-		item.index = c.pq.Len()
+		c.makeRoom(ctx, cost)
+		item = &simpleItem{
+			expiration: expiration{key: key},
+			value:      value,
+			cost:       cost,
+		}
 		c.items[key] = item
-
-		entry.item = &item
-		entry.priority = item.expireAt.UnixNano()
-		heap.Push(&c.pq, entry)
+		c.expiry.set(&item.expiration, deadline(c.clock.Now(), ttl), ttl > 0)
+		c.used += cost
 	}
 
 	return nil
 }
 
+// Get only takes the read lock, hits don't change the eviction order. Expired
+// entries are left for eviction or the sweeper to reclaim.
 func (c *SimpleCache) Get(ctx context.Context, key string) (interface{}, error) {
-	c.Lock()
-	defer c.Unlock()
+	c.RLock()
+	defer c.RUnlock()
 
 	item, ok := c.items[key]
 	if ok {
 		if !item.IsExpired(c.clock) {
+			c.counters.hit()
 			return item.value, nil
 		}
-		c.remove(ctx, key)
+		c.counters.miss()
 		return nil, KeyExpiredError
 	}
 
+	c.counters.miss()
 	return nil, KeyNotFoundError
 }
 
 func (c *SimpleCache) Exists(ctx context.Context, key string) bool {
-	c.Lock()
-	defer c.Unlock()
+	c.RLock()
+	defer c.RUnlock()
 
 	item, ok := c.items[key]
-	if !ok {
-		return false
-	}
+	return ok && !item.IsExpired(c.clock)
+}
+
+// Deadline returns the deadline of the live entry of key, zero without a TTL,
+// and the load time recorded for it by SetLoadTime.
+func (c *SimpleCache) Deadline(ctx context.Context, key string) (time.Time, time.Duration, bool) {
+	c.RLock()
+	defer c.RUnlock()
 
-	if item.IsExpired(c.clock) {
-		c.remove(ctx, key)
-		return false
+	item, ok := c.items[key]
+	if !ok || item.IsExpired(c.clock) {
+		return time.Time{}, 0, false
 	}
-	return true
+	return item.deadline(), item.loadTime, true
 }
 
-func (c *SimpleCache) Remove(ctx context.Context, key string) bool {
+// SetLoadTime records how long the value of the entry of key took to load.
+func (c *SimpleCache) SetLoadTime(ctx context.Context, key string, d time.Duration) {
 	c.Lock()
 	defer c.Unlock()
 
-	return c.remove(ctx, key)
+	if item, ok := c.items[key]; ok {
+		item.loadTime = d
+	}
+}
+
+// Remove reports whether a live entry was removed, expired ones are removed
+// as EvictExpired whatever reason says.
+func (c *SimpleCache) Remove(ctx context.Context, key string, reason EvictReason) bool {
+	c.Lock()
+	defer c.listener.unlock(c)
+
+	item, ok := c.items[key]
+	c.remove(ctx, key, reason)
+	return ok && !item.IsExpired(c.clock)
 }
 
 func (c *SimpleCache) Evict(ctx context.Context, count int) {
 	c.Lock()
-	defer c.Unlock()
+	defer c.listener.unlock(c)
 
 	c.evict(ctx, count)
 }
 
-func (c *SimpleCache) remove(ctx context.Context, key string) bool {
-	item, ok := c.items[key]
-	if ok {
-		heap.Remove(&c.pq, item.index)
-		delete(c.items, key)
-		return true
-	}
-	return false
-}
+func (c *SimpleCache) Resize(ctx context.Context, capacity int, maxBytes int64) {
+	c.Lock()
+	defer c.listener.unlock(c)
 
-func (c *SimpleCache) evict(ctx context.Context, count int) {
-	if len(c.items) < c.cap {
-		return
+	c.cap = capacity
+	if c.weigher.resize(maxBytes) {
+		for _, item := range c.items {
+			item.cost = c.cost(item.key, item.value)
+			c.used += item.cost
+		}
 	}
 
-	cnt := 0
-	now := c.clock.Now()
-	if n := c.pq.Len(); n > 0 {
-		entry := c.pq[0]
-		item := c.items[entry.key]
-		if now.After(item.expireAt) {
-			heap.Pop(&c.pq)
-			delete(c.items, entry.key)
-			cnt++
+	for len(c.items) > c.cap || c.overflow(0) {
+		if !c.removeVictim(ctx) {
+			return
 		}
 	}
+}
 
-	for k, v := range c.items {
-		if cnt >= count {
+// Range walks the entries in no particular order, SimpleCache doesn't track
+// hotness.
+func (c *SimpleCache) Range(ctx context.Context, fn func(Entry) bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	for _, item := range c.items {
+		if item.IsExpired(c.clock) {
+			continue
+		}
+		if !fn(Entry{Key: item.key, Value: item.value, ExpireAt: item.deadline(), LoadTime: item.loadTime}) {
 			return
 		}
+	}
+}
 
-		heap.Remove(&c.pq, v.index)
-		delete(c.items, k)
-		cnt++
+func (c *SimpleCache) Insert(ctx context.Context, e Entry) error {
+	c.Lock()
+	defer c.listener.unlock(c)
+
+	if _, ok := c.items[e.Key]; ok {
+		return nil
+	}
+
+	now := c.clock.Now()
+	expireAt, expires := entryDeadline(now, e)
+	if expireAt.Before(now) {
+		return nil
 	}
+
+	cost := c.cost(e.Key, e.Value)
+	if c.tooLarge(cost) {
+		return ValueTooLargeError
+	}
+
+	if !c.fits(ctx, cost) {
+		return ShardFullError
+	}
+	item := &simpleItem{
+		expiration: expiration{key: e.Key, loadTime: e.LoadTime},
+		value:      e.Value,
+		cost:       cost,
+	}
+	c.items[e.Key] = item
+	c.expiry.set(&item.expiration, expireAt, expires)
+	c.used += cost
+	return nil
 }
 
-type simpleItem struct {
-	value    interface{}
-	expireAt time.Time
-	index    int
+func (c *SimpleCache) remove(ctx context.Context, key string, reason EvictReason) bool {
+	item, ok := c.items[key]
+	if ok {
+		reason = evictReason(c.clock, &item.expiration, reason)
+		c.counters.evicted(reason)
+		c.listener.add(&item.expiration, item.value, reason)
+		c.expiry.remove(&item.expiration)
+		delete(c.items, key)
+		c.used -= item.cost
+		return true
+	}
+	return false
 }
 
-func (si simpleItem) IsExpired(clock Clock) bool {
-	return si.expireAt.Before(clock.Now())
+func (c *SimpleCache) Stats() ShardStats {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.counters.stats(len(c.items))
 }
 
-type simpleEntry struct {
-	key      string
-	item     *simpleItem
-	priority int64
-	index    int
+func (c *SimpleCache) Sweep(ctx context.Context) int {
+	c.Lock()
+	defer c.listener.unlock(c)
+
+	n := 0
+	now := c.clock.Now()
+	for e := c.expiry.expired(now); e != nil; e = c.expiry.expired(now) {
+		c.remove(ctx, e.key, EvictExpired)
+		n++
+	}
+	return n
 }
 
-type simplepq []simpleEntry
+func (c *SimpleCache) evict(ctx context.Context, count int) {
+	if len(c.items) < c.cap && !c.overflow(0) {
+		return
+	}
 
-func (pq simplepq) Len() int { return len(pq) }
+	for i := 0; i < count; i++ {
+		if !c.removeVictim(ctx) {
+			return
+		}
+	}
+}
 
-func (pq simplepq) Less(i, j int) bool {
-	return pq[i].priority < pq[j].priority
+// makeRoom evicts until an entry weighing cost fits.
+func (c *SimpleCache) makeRoom(ctx context.Context, cost int64) {
+	for len(c.items) >= c.cap || c.overflow(cost) {
+		if !c.removeVictim(ctx) {
+			return
+		}
+	}
 }
 
-func (pq simplepq) Swap(i, j int) {
-	pq[i], pq[j] = pq[j], pq[i]
-	pq[i].index = i
-	pq[i].item.index = i
-	pq[j].index = j
-	pq[j].item.index = j
+// fits removes expired entries until an entry weighing cost fits, and reports
+// whether it does without evicting a live one.
+func (c *SimpleCache) fits(ctx context.Context, cost int64) bool {
+	for len(c.items) >= c.cap || c.overflow(cost) {
+		e := c.expiry.expired(c.clock.Now())
+		if e == nil {
+			return false
+		}
+		c.remove(ctx, e.key, EvictExpired)
+	}
+	return true
 }
 
-func (pq *simplepq) Push(x interface{}) {
-	n := len(*pq)
-	entry := x.(simpleEntry)
-	entry.index = n
-	*pq = append(*pq, entry)
+// removeVictim drops the entry closest to its deadline, so expired entries
+// always go first.
+func (c *SimpleCache) removeVictim(ctx context.Context) bool {
+	if e := c.expiry.peek(); e != nil {
+		return c.remove(ctx, e.key, EvictCapacity)
+	}
+	return false
 }
 
-func (pq *simplepq) Pop() interface{} {
-	old := *pq
-	n := len(old)
-	entry := old[0]
-	entry.index = -1
-	*pq = old[1:n]
-	return entry
+// shrink evicts entries other than keep after it grew past the byte budget.
+func (c *SimpleCache) shrink(ctx context.Context, keep *simpleItem) {
+	if !c.overflow(0) {
+		return
+	}
+
+	c.expiry.remove(&keep.expiration)
+	for c.overflow(0) {
+		if !c.removeVictim(ctx) {
+			break
+		}
+	}
+	c.expiry.set(&keep.expiration, keep.expireAt, true)
 }
 
-func (pq *simplepq) update(index int) {
-	heap.Fix(pq, index)
+type simpleItem struct {
+	expiration
+	value interface{}
+	cost  int64
 }
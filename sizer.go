@@ -0,0 +1,178 @@
+package mcache
+
+import (
+	"reflect"
+	"unsafe"
+)
+
+// Sizer reports the approximate number of bytes a cached value occupies.
+// Policies charge every entry len(key)+Sizeof(value) against their byte
+// budget when one is configured with WithMaxBytes.
+type Sizer interface {
+	Sizeof(value interface{}) int64
+}
+
+// SizerFunc adapts an ordinary function to the Sizer interface.
+type SizerFunc func(value interface{}) int64
+
+func (f SizerFunc) Sizeof(value interface{}) int64 {
+	return f(value)
+}
+
+// DefaultSizer returns the estimator used when WithMaxBytes is set without
+// WithSizer. Strings and []byte are charged by length, everything else is
+// walked with reflect, following pointers, slices, maps and interfaces.
+func DefaultSizer() Sizer {
+	return defaultSizer{}
+}
+
+type defaultSizer struct{}
+
+func (defaultSizer) Sizeof(value interface{}) int64 {
+	switch v := value.(type) {
+	case nil:
+		return 0
+	case string:
+		return int64(len(v))
+	case []byte:
+		return int64(cap(v))
+	}
+
+	return sizeofValue(reflect.ValueOf(value), make(map[uintptr]struct{}))
+}
+
+// sizeofValue returns the inline size of v plus everything it references.
+func sizeofValue(v reflect.Value, seen map[uintptr]struct{}) int64 {
+	return int64(v.Type().Size()) + sizeofIndirect(v, seen)
+}
+
+// sizeofIndirect returns the number of bytes v references outside of its own
+// inline representation. Shared pointers are only counted once.
+func sizeofIndirect(v reflect.Value, seen map[uintptr]struct{}) int64 {
+	switch v.Kind() {
+	case reflect.String:
+		return int64(v.Len())
+	case reflect.Ptr:
+		if v.IsNil() || visited(v.Pointer(), seen) {
+			return 0
+		}
+		return sizeofValue(v.Elem(), seen)
+	case reflect.Interface:
+		if v.IsNil() {
+			return 0
+		}
+		return sizeofValue(v.Elem(), seen)
+	case reflect.Slice:
+		if v.IsNil() || visited(v.Pointer(), seen) {
+			return 0
+		}
+		size := int64(v.Cap()) * int64(v.Type().Elem().Size())
+		if hasIndirect(v.Type().Elem()) {
+			for i := 0; i < v.Len(); i++ {
+				size += sizeofIndirect(v.Index(i), seen)
+			}
+		}
+		return size
+	case reflect.Array:
+		var size int64
+		if hasIndirect(v.Type().Elem()) {
+			for i := 0; i < v.Len(); i++ {
+				size += sizeofIndirect(v.Index(i), seen)
+			}
+		}
+		return size
+	case reflect.Struct:
+		var size int64
+		for i := 0; i < v.NumField(); i++ {
+			size += sizeofIndirect(v.Field(i), seen)
+		}
+		return size
+	case reflect.Map:
+		if v.IsNil() || visited(v.Pointer(), seen) {
+			return 0
+		}
+		var size int64
+		iter := v.MapRange()
+		for iter.Next() {
+			size += sizeofValue(iter.Key(), seen) + sizeofValue(iter.Value(), seen)
+		}
+		return size + int64(v.Len())*int64(unsafe.Sizeof(uintptr(0)))
+	default:
+		return 0
+	}
+}
+
+func visited(ptr uintptr, seen map[uintptr]struct{}) bool {
+	if _, ok := seen[ptr]; ok {
+		return true
+	}
+	seen[ptr] = struct{}{}
+	return false
+}
+
+// hasIndirect reports whether values of type t may reference memory outside of
+// their inline representation.
+func hasIndirect(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map:
+		return true
+	case reflect.Array:
+		return hasIndirect(t.Elem())
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if hasIndirect(t.Field(i).Type) {
+				return true
+			}
+		}
+		return false
+	default:
+		return false
+	}
+}
+
+// weigher keeps track of the bytes charged to a single policy shard.
+type weigher struct {
+	sizer    Sizer
+	maxBytes int64
+	used     int64
+}
+
+func (w *weigher) init(o policyOptions) {
+	w.sizer = o.Sizer
+	w.maxBytes = o.MaxBytes
+	w.used = 0
+	if w.maxBytes > 0 && w.sizer == nil {
+		w.sizer = DefaultSizer()
+	}
+}
+
+// resize changes the byte budget, it reports whether the budget was turned on
+// or off, in which case every entry must be weighed again.
+func (w *weigher) resize(maxBytes int64) bool {
+	reweigh := (w.maxBytes > 0) != (maxBytes > 0)
+	w.maxBytes = maxBytes
+	if w.maxBytes > 0 && w.sizer == nil {
+		w.sizer = DefaultSizer()
+	}
+	if reweigh {
+		w.used = 0
+	}
+	return reweigh
+}
+
+// cost returns the weight of an entry, it is always 0 without a byte budget so
+// the reflect walk is skipped.
+func (w *weigher) cost(key string, value interface{}) int64 {
+	if w.maxBytes <= 0 {
+		return 0
+	}
+	return int64(len(key)) + w.sizer.Sizeof(value)
+}
+
+func (w *weigher) tooLarge(cost int64) bool {
+	return w.maxBytes > 0 && cost > w.maxBytes
+}
+
+func (w *weigher) overflow(incoming int64) bool {
+	return w.maxBytes > 0 && w.used+incoming > w.maxBytes
+}
@@ -0,0 +1,50 @@
+package mcache
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultSizer(t *testing.T) {
+	type inner struct {
+		Name string
+		Tags []string
+	}
+	type outer struct {
+		ID    int64
+		Inner *inner
+		Attrs map[string]string
+	}
+
+	s := DefaultSizer()
+	assert.Equal(t, int64(5), s.Sizeof("hello"))
+	assert.Equal(t, int64(64), s.Sizeof(make([]byte, 10, 64)))
+	assert.Equal(t, int64(0), s.Sizeof(nil))
+
+	small := s.Sizeof(outer{ID: 1})
+	big := s.Sizeof(outer{
+		ID:    1,
+		Inner: &inner{Name: string(make([]byte, 100)), Tags: []string{"a", "b"}},
+		Attrs: map[string]string{"k": "v"},
+	})
+	assert.Greater(t, big, small+100)
+
+	shared := &inner{Name: string(make([]byte, 100))}
+	twice := s.Sizeof([]*inner{shared, shared})
+	assert.Less(t, twice, int64(200))
+}
+
+func TestMaxBytesBelowShardCount(t *testing.T) {
+	ctx := context.TODO()
+	c := New[LruCache](64, WithShardCount(4), WithMaxBytes(2))
+	defer c.Close()
+
+	// every shard holds a single byte, none is left unbounded.
+	for i := 0; i < 16; i++ {
+		assert.Equal(t, ValueTooLargeError, c.Set(ctx, fmt.Sprint(i), "ab"))
+	}
+	assert.Equal(t, 0, c.Stats().Size)
+}
@@ -0,0 +1,196 @@
+package mcache
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+// snapshotMagic starts every snapshot, its last byte is the format version.
+const snapshotMagic = "mcache\x00\x01"
+
+const maxSnapshotField = 1 << 30 //快照中键或值的最大长度
+
+// Snapshot writes every live entry of the local shards to w, hottest first
+// per shard, with its remaining TTL and the frequency tracked by the policy.
+// Values are encoded with the codec of the cache, without one Snapshot fails
+// with SerializeError. A Resize waits for Snapshot to copy the entries out of
+// the shards, not for them to be written.
+func (c *cacheHandler[T, P]) Snapshot(ctx context.Context, w io.Writer) error {
+	return c.snapshot(ctx, w, 0)
+}
+
+// snapshot takes the entries from the shards in turn, so every shard gives
+// its hottest entries first. It stops before the entry that would make the
+// snapshot larger than maxBytes, if positive.
+func (c *cacheHandler[T, P]) snapshot(ctx context.Context, w io.Writer, maxBytes int64) error {
+	if c.serializeFunc == nil {
+		return SerializeError
+	}
+
+	c.resizeMu.Lock()
+	shards := c.loadTable().shards
+	entries := make([][]Entry, len(shards))
+	for i, s := range shards {
+		s.Range(ctx, func(e Entry) bool {
+			entries[i] = append(entries[i], e)
+			return true
+		})
+	}
+	c.resizeMu.Unlock()
+
+	sw := snapshotWriter{w: bufio.NewWriter(w)}
+	sw.w.WriteString(snapshotMagic)
+	written := int64(len(snapshotMagic))
+
+	now := c.clock.Now()
+	for i, left := 0, len(shards); left > 0; i++ {
+		left = 0
+		for _, es := range entries {
+			if i >= len(es) {
+				continue
+			}
+			left++
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			e := es[i]
+			var ttl time.Duration
+			if !e.ExpireAt.IsZero() {
+				if ttl = e.ExpireAt.Sub(now); ttl <= 0 {
+					continue
+				}
+			}
+			data, err := c.serializeFunc(ctx, e.Value)
+			if err != nil {
+				return err
+			}
+
+			sw.rec = sw.rec[:0]
+			sw.bytes([]byte(e.Key))
+			sw.uvarint(uint64(ttl))
+			sw.uvarint(e.Freq)
+			sw.bytes(data)
+			if maxBytes > 0 && written+int64(len(sw.rec)) > maxBytes {
+				return sw.w.Flush()
+			}
+			sw.w.Write(sw.rec)
+			written += int64(len(sw.rec))
+		}
+	}
+	return sw.w.Flush()
+}
+
+// Restore adds the entries of a snapshot to the local shards, keeping their
+// remaining TTL, frequency and order. Keys already present are left alone, as
+// are entries larger than the byte budget of their shard. Once a shard is full
+// of live entries, the colder entries that follow are dropped.
+func (c *cacheHandler[T, P]) Restore(ctx context.Context, r io.Reader) error {
+	if c.deserializeFunc == nil {
+		return SerializeError
+	}
+
+	sr := snapshotReader{r: bufio.NewReader(r)}
+	magic := make([]byte, len(snapshotMagic))
+	if _, err := io.ReadFull(sr.r, magic); err != nil || string(magic) != snapshotMagic {
+		return SnapshotFormatError
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		key, err := sr.bytes()
+		if err == io.EOF {
+			return nil
+		}
+		ttl, err := sr.uvarint(err)
+		freq, err := sr.uvarint(err)
+		data, err := sr.bytesAfter(err)
+		if err != nil {
+			return SnapshotFormatError
+		}
+
+		val, err := c.deserializeFunc(ctx, data)
+		if err != nil {
+			return err
+		}
+		e := Entry{Key: string(key), Value: val, Freq: freq}
+		if ttl > 0 {
+			e.ExpireAt = c.clock.Now().Add(time.Duration(ttl))
+		}
+		if err := c.localInsert(ctx, e); err != nil && !errors.Is(err, ValueTooLargeError) && !errors.Is(err, ShardFullError) {
+			return err
+		}
+	}
+}
+
+// snapshotWriter encodes a record of a snapshot into rec, write errors are
+// kept by the bufio.Writer until Flush.
+type snapshotWriter struct {
+	w       *bufio.Writer
+	rec     []byte
+	scratch [binary.MaxVarintLen64]byte
+}
+
+func (s *snapshotWriter) uvarint(v uint64) {
+	n := binary.PutUvarint(s.scratch[:], v)
+	s.rec = append(s.rec, s.scratch[:n]...)
+}
+
+func (s *snapshotWriter) bytes(b []byte) {
+	s.uvarint(uint64(len(b)))
+	s.rec = append(s.rec, b...)
+}
+
+// snapshotReader decodes the fields of a snapshot. Its methods taking an error
+// do nothing if it isn't nil, so a record is read field after field and
+// checked once.
+type snapshotReader struct {
+	r *bufio.Reader
+}
+
+func (s *snapshotReader) uvarint(err error) (uint64, error) {
+	if err != nil {
+		return 0, err
+	}
+	v, err := binary.ReadUvarint(s.r)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return v, err
+}
+
+// bytes reads the first field of a record, io.EOF means there is no record
+// left.
+func (s *snapshotReader) bytes() ([]byte, error) {
+	n, err := binary.ReadUvarint(s.r)
+	if err != nil {
+		return nil, err
+	}
+	return s.read(n)
+}
+
+func (s *snapshotReader) bytesAfter(err error) ([]byte, error) {
+	n, err := s.uvarint(err)
+	if err != nil {
+		return nil, err
+	}
+	return s.read(n)
+}
+
+func (s *snapshotReader) read(n uint64) ([]byte, error) {
+	if n > maxSnapshotField {
+		return nil, SnapshotFormatError
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(s.r, b); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return b, nil
+}
@@ -0,0 +1,104 @@
+package mcache
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshot(t *testing.T) {
+	var (
+		ctx   = context.TODO()
+		fc    = NewFakeClock()
+		codec = WithUnSafeValBind(func() interface{} { return new(string) })
+		src   = New[LruCache](8, WithShardCount(1), WithClock(fc), codec)
+		buf   bytes.Buffer
+	)
+	defer src.Close()
+
+	for i := 0; i < 4; i++ {
+		assert.Nil(t, src.Set(ctx, fmt.Sprint(i), fmt.Sprint("v", i)))
+	}
+	assert.Nil(t, src.Set(ctx, "ttl", "v", WithTTL(time.Second)))
+	src.Get(ctx, "0")
+	fc.Advance(400 * time.Millisecond)
+	assert.Nil(t, src.Snapshot(ctx, &buf))
+
+	fc2 := NewFakeClock()
+	dst := New[LruCache](8, WithShardCount(1), WithClock(fc2), codec).(*cacheHandler[LruCache, *LruCache])
+	defer dst.Close()
+	assert.Nil(t, dst.Set(ctx, "1", "newer"))
+	assert.Nil(t, dst.Restore(ctx, bytes.NewReader(buf.Bytes())))
+
+	var order []string
+	dst.loadTable().shards[0].Range(ctx, func(e Entry) bool {
+		order = append(order, e.Key)
+		return true
+	})
+	assert.Equal(t, []string{"1", "0", "ttl", "3", "2"}, order)
+
+	val, err := dst.Get(ctx, "0")
+	assert.Nil(t, err)
+	assert.Equal(t, "v0", val)
+	val, err = dst.Get(ctx, "1")
+	assert.Nil(t, err)
+	assert.Equal(t, "newer", val)
+
+	fc2.Advance(500 * time.Millisecond)
+	assert.True(t, dst.Exists(ctx, "ttl"))
+	fc2.Advance(101 * time.Millisecond)
+	assert.False(t, dst.Exists(ctx, "ttl"))
+}
+
+func TestSnapshotFrequency(t *testing.T) {
+	var (
+		ctx   = context.TODO()
+		codec = WithUnSafeValBind(func() interface{} { return new(int) })
+		src   = New[LfuCache](8, WithShardCount(1), codec)
+		dst   = New[LfuCache](8, WithShardCount(1), codec).(*cacheHandler[LfuCache, *LfuCache])
+		buf   bytes.Buffer
+	)
+	defer src.Close()
+	defer dst.Close()
+
+	assert.Nil(t, src.Set(ctx, "hot", 1))
+	assert.Nil(t, src.Set(ctx, "cold", 2))
+	for i := 0; i < 3; i++ {
+		src.Get(ctx, "hot")
+	}
+	assert.Nil(t, src.Snapshot(ctx, &buf))
+	assert.Nil(t, dst.Restore(ctx, &buf))
+
+	freqs := make(map[string]uint64)
+	dst.loadTable().shards[0].Range(ctx, func(e Entry) bool {
+		freqs[e.Key] = e.Freq
+		return true
+	})
+	assert.Greater(t, freqs["hot"], freqs["cold"])
+}
+
+func TestRestoreInvalid(t *testing.T) {
+	var (
+		ctx   = context.TODO()
+		codec = WithUnSafeValBind(func() interface{} { return new(string) })
+		src   = New[SimpleCache](8, codec)
+		dst   = New[SimpleCache](8, codec)
+		plain = New[SimpleCache](8)
+		buf   bytes.Buffer
+	)
+	defer src.Close()
+	defer dst.Close()
+	defer plain.Close()
+
+	assert.Equal(t, SerializeError, plain.Snapshot(ctx, &buf))
+	assert.Equal(t, SnapshotFormatError, dst.Restore(ctx, bytes.NewReader([]byte("not a snapshot"))))
+
+	assert.Nil(t, src.Set(ctx, "k", "v"))
+	assert.Nil(t, src.Snapshot(ctx, &buf))
+	truncated := buf.Bytes()[:buf.Len()-1]
+	assert.Equal(t, SnapshotFormatError, dst.Restore(ctx, bytes.NewReader(truncated)))
+}
@@ -0,0 +1,310 @@
+package mcache
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// EvictReason tells why an entry left a shard.
+type EvictReason int
+
+const (
+	// EvictCapacity entries made room for others.
+	EvictCapacity EvictReason = iota
+	// EvictExpired entries outlived their TTL.
+	EvictExpired
+	// EvictExplicit entries were removed by Remove or MRemove.
+	EvictExplicit
+	// EvictReplaced values were overwritten by a Set of their key.
+	EvictReplaced
+
+	evictReasons
+	// evictMigrated entries moved to another shard on Resize, they are
+	// neither counted nor reported.
+	evictMigrated
+)
+
+func (r EvictReason) String() string {
+	switch r {
+	case EvictCapacity:
+		return "capacity"
+	case EvictExpired:
+		return "expired"
+	case EvictExplicit:
+		return "explicit"
+	case EvictReplaced:
+		return "replaced"
+	default:
+		return "unknown"
+	}
+}
+
+// EvictionStats counts evictions by reason.
+type EvictionStats struct {
+	Capacity uint64
+	Expired  uint64
+	Explicit uint64
+	Replaced uint64
+}
+
+func (s *EvictionStats) add(o EvictionStats) {
+	s.Capacity += o.Capacity
+	s.Expired += o.Expired
+	s.Explicit += o.Explicit
+	s.Replaced += o.Replaced
+}
+
+// ShardStats describes a single local shard.
+type ShardStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions EvictionStats
+	Size      int
+	// Adaptive is the eviction mix of an AdaptiveCache shard, nil for the
+	// other policies.
+	Adaptive *AdaptiveStats
+}
+
+// Stats describes a cache since it was created.
+type Stats struct {
+	// Hits is LocalHits + DiskHits + RemoteHits.
+	Hits uint64
+	// Misses counts the keys found neither locally nor remotely.
+	Misses     uint64
+	LocalHits  uint64
+	DiskHits   uint64
+	RemoteHits uint64
+
+	// LoadSuccesses and LoadFailures count the calls to the loader functions,
+	// LoadTime is the total time spent in them.
+	LoadSuccesses uint64
+	LoadFailures  uint64
+	LoadTime      time.Duration
+	LoadLatency   Histogram
+	// EarlyReloads counts the live entries Get reloaded ahead of their
+	// expiration, see WithXFetch.
+	EarlyReloads uint64
+
+	// Redis describes the calls to Redis by operation: get, mget, set, mset,
+	// del, mdel, and lease and release for WithLease.
+	Redis map[string]RedisStats
+	// WriteBehind describes the queue of WithWriteBehind.
+	WriteBehind WriteBehindStats
+	// Breaker describes the breaker of WithCircuitBreaker.
+	Breaker BreakerStats
+	// Invalidation describes the background work of Invalidate.
+	Invalidation InvalidationStats
+	// Lease describes the loads under a lease of WithLease.
+	Lease LeaseStats
+
+	Evictions EvictionStats
+	Size      int
+	Shards    []ShardStats
+}
+
+// RedisStats describes the calls of one Redis operation, a pipeline counts as
+// a single call.
+type RedisStats struct {
+	Errors  uint64
+	Latency Histogram
+}
+
+// LatencyBuckets are the upper bounds of the latency histograms.
+var LatencyBuckets = []time.Duration{
+	100 * time.Microsecond,
+	250 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// Histogram is a snapshot of a latency histogram over LatencyBuckets.
+// Counts[i] counts the observations in (LatencyBuckets[i-1],
+// LatencyBuckets[i]], the extra last count those above the last bucket.
+type Histogram struct {
+	Counts []uint64
+	Count  uint64
+	Sum    time.Duration
+}
+
+// shardCounters are kept by every policy, they are updated atomically so
+// hits can be counted under the read lock.
+type shardCounters struct {
+	hits      uint64
+	misses    uint64
+	evictions [evictReasons]uint64
+}
+
+func (s *shardCounters) hit() {
+	atomic.AddUint64(&s.hits, 1)
+}
+
+func (s *shardCounters) miss() {
+	atomic.AddUint64(&s.misses, 1)
+}
+
+func (s *shardCounters) evicted(reason EvictReason) {
+	if reason >= evictReasons {
+		return
+	}
+	atomic.AddUint64(&s.evictions[reason], 1)
+}
+
+func (s *shardCounters) stats(size int) ShardStats {
+	return ShardStats{
+		Hits:   atomic.LoadUint64(&s.hits),
+		Misses: atomic.LoadUint64(&s.misses),
+		Evictions: EvictionStats{
+			Capacity: atomic.LoadUint64(&s.evictions[EvictCapacity]),
+			Expired:  atomic.LoadUint64(&s.evictions[EvictExpired]),
+			Explicit: atomic.LoadUint64(&s.evictions[EvictExplicit]),
+			Replaced: atomic.LoadUint64(&s.evictions[EvictReplaced]),
+		},
+		Size: size,
+	}
+}
+
+// evictReason returns EvictExpired for an expired entry, reason otherwise.
+func evictReason(clock Clock, e *expiration, reason EvictReason) EvictReason {
+	if reason < evictReasons && e.IsExpired(clock) {
+		return EvictExpired
+	}
+	return reason
+}
+
+// histogram counts latencies over LatencyBuckets atomically.
+type histogram struct {
+	counts []uint64
+	sum    int64
+}
+
+func newHistogram() histogram {
+	return histogram{counts: make([]uint64, len(LatencyBuckets)+1)}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	i := sort.Search(len(LatencyBuckets), func(i int) bool { return d <= LatencyBuckets[i] })
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddInt64(&h.sum, int64(d))
+}
+
+func (h *histogram) snapshot() Histogram {
+	s := Histogram{
+		Counts: make([]uint64, len(h.counts)),
+		Sum:    time.Duration(atomic.LoadInt64(&h.sum)),
+	}
+	for i := range h.counts {
+		s.Counts[i] = atomic.LoadUint64(&h.counts[i])
+		s.Count += s.Counts[i]
+	}
+	return s
+}
+
+type redisOp int
+
+const (
+	redisGet redisOp = iota
+	redisMGet
+	redisSet
+	redisMSet
+	redisDel
+	redisMDel
+	redisLease
+	redisRelease
+
+	redisOps
+)
+
+var redisOpNames = [redisOps]string{"get", "mget", "set", "mset", "del", "mdel", "lease", "release"}
+
+type redisStats struct {
+	errors  [redisOps]uint64
+	latency [redisOps]histogram
+}
+
+func newRedisStats() *redisStats {
+	s := &redisStats{}
+	for i := range s.latency {
+		s.latency[i] = newHistogram()
+	}
+	return s
+}
+
+// observe records a call to op that started at start, a missing key is not
+// an error.
+func (s *redisStats) observe(op redisOp, start time.Time, err error) {
+	if s == nil {
+		return
+	}
+	s.latency[op].observe(time.Since(start))
+	if err != nil && err != redis.Nil && err != KeyNotFoundError {
+		atomic.AddUint64(&s.errors[op], 1)
+	}
+}
+
+func (s *redisStats) snapshot() map[string]RedisStats {
+	m := make(map[string]RedisStats, redisOps)
+	for op, name := range redisOpNames {
+		m[name] = RedisStats{
+			Errors:  atomic.LoadUint64(&s.errors[op]),
+			Latency: s.latency[op].snapshot(),
+		}
+	}
+	return m
+}
+
+// cacheStats are the counters of a cache that no shard can keep.
+type cacheStats struct {
+	localMisses   uint64
+	diskHits      uint64
+	remoteHits    uint64
+	loadSuccesses uint64
+	loadFailures  uint64
+	loadLatency   histogram
+	redis         *redisStats
+	lease         leaseStats
+	earlyReloads  uint64
+}
+
+func newCacheStats() *cacheStats {
+	return &cacheStats{
+		loadLatency: newHistogram(),
+		redis:       newRedisStats(),
+	}
+}
+
+func (s *cacheStats) localMiss(n int) {
+	atomic.AddUint64(&s.localMisses, uint64(n))
+}
+
+func (s *cacheStats) diskHit(n int) {
+	atomic.AddUint64(&s.diskHits, uint64(n))
+}
+
+func (s *cacheStats) remoteHit(n int) {
+	atomic.AddUint64(&s.remoteHits, uint64(n))
+}
+
+// loaded records a loader call that took d.
+func (s *cacheStats) loaded(d time.Duration, err error) {
+	s.loadLatency.observe(d)
+	if err != nil {
+		atomic.AddUint64(&s.loadFailures, 1)
+	} else {
+		atomic.AddUint64(&s.loadSuccesses, 1)
+	}
+}
@@ -0,0 +1,169 @@
+package mcache
+
+import "time"
+
+const (
+	wheelBits   = 6
+	wheelSlots  = 1 << wheelBits
+	wheelMask   = wheelSlots - 1
+	wheelLevels = 4
+)
+
+// timingWheel is a hierarchical timing wheel implementing expiryIndex. Slots of
+// level l span tick*64^l, items are cascaded to the level below when the wheel
+// reaches their slot, so insert, remove and expiry are O(1) amortized, even
+// after a long pause. Items further away than 64^4 ticks wait in overflow,
+// items whose deadline passed wait in due until the policy removes them.
+type timingWheel struct {
+	tick     int64
+	cur      int64
+	levels   [wheelLevels][wheelSlots]wheelSlot
+	overflow wheelSlot
+	due      wheelSlot
+	indexAll bool
+	n        int
+}
+
+func newTimingWheel(now time.Time, tick time.Duration, indexAll bool) *timingWheel {
+	return &timingWheel{
+		tick:     int64(tick),
+		cur:      now.UnixNano() / int64(tick),
+		indexAll: indexAll,
+	}
+}
+
+func (w *timingWheel) set(e *expiration, expireAt time.Time, expires bool) {
+	w.remove(e)
+	e.expireAt = expireAt
+	e.expires = expires
+	if !expires && !w.indexAll {
+		return
+	}
+
+	w.place(e)
+	w.n++
+}
+
+func (w *timingWheel) remove(e *expiration) {
+	if e.slot != nil {
+		e.slot.unlink(e)
+		w.n--
+	}
+}
+
+func (w *timingWheel) expired(now time.Time) *expiration {
+	w.advance(now.UnixNano() / w.tick)
+	return w.due.head
+}
+
+func (w *timingWheel) peek() *expiration {
+	if w.due.head != nil {
+		return w.due.head
+	}
+
+	for l := 0; l < wheelLevels; l++ {
+		start := (w.cur >> (wheelBits * l)) + 1
+		for i := int64(0); i < wheelSlots; i++ {
+			if e := w.levels[l][(start+i)&wheelMask].head; e != nil {
+				return e
+			}
+		}
+	}
+	return w.overflow.head
+}
+
+func (w *timingWheel) Len() int {
+	return w.n
+}
+
+// place links e into the slot matching its deadline, rounded up to a tick so
+// an item is never reported before its deadline.
+func (w *timingWheel) place(e *expiration) {
+	ns := e.expireAt.UnixNano()
+	t := ns / w.tick
+	if ns%w.tick != 0 {
+		t++
+	}
+
+	diff := t - w.cur
+	if diff <= 0 {
+		w.due.push(e)
+		return
+	}
+
+	for l := 0; l < wheelLevels; l++ {
+		if diff < 1<<(wheelBits*(l+1)) {
+			w.levels[l][(t>>(wheelBits*l))&wheelMask].push(e)
+			return
+		}
+	}
+	w.overflow.push(e)
+}
+
+// advance moves the wheel to tick to. The items of the slots entered on the
+// way, at most 64 per level whatever the jump, are placed again relative to
+// to: the ones due move to due, the others down a level.
+func (w *timingWheel) advance(to int64) {
+	if to <= w.cur {
+		return
+	}
+
+	from := w.cur
+	w.cur = to
+	for l := 0; l < wheelLevels; l++ {
+		shift := wheelBits * l
+		entered := to>>shift - from>>shift
+		if entered == 0 {
+			break
+		}
+		if entered > wheelSlots {
+			entered = wheelSlots
+		}
+		for k := to >> shift; entered > 0; k, entered = k-1, entered-1 {
+			w.cascade(&w.levels[l][k&wheelMask])
+		}
+	}
+	if to>>(wheelBits*wheelLevels) != from>>(wheelBits*wheelLevels) {
+		w.cascade(&w.overflow)
+	}
+}
+
+// cascade places every item of s again relative to the current tick, some may
+// land in s again.
+func (w *timingWheel) cascade(s *wheelSlot) {
+	e := s.head
+	s.head = nil
+	for e != nil {
+		next := e.next
+		e.slot, e.prev, e.next = nil, nil, nil
+		w.place(e)
+		e = next
+	}
+}
+
+// wheelSlot is an intrusive doubly linked list of items.
+type wheelSlot struct {
+	head *expiration
+}
+
+func (s *wheelSlot) push(e *expiration) {
+	e.slot = s
+	e.prev = nil
+	e.next = s.head
+	if s.head != nil {
+		s.head.prev = e
+	}
+	s.head = e
+}
+
+func (s *wheelSlot) unlink(e *expiration) {
+	if e.prev != nil {
+		e.prev.next = e.next
+	} else {
+		s.head = e.next
+	}
+	if e.next != nil {
+		e.next.prev = e.prev
+	}
+	e.slot, e.prev, e.next = nil, nil, nil
+}
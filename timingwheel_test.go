@@ -0,0 +1,95 @@
+package mcache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimingWheel(t *testing.T) {
+	var (
+		fc    = NewFakeClock()
+		tick  = 10 * time.Millisecond
+		w     = newTimingWheel(fc.Now(), tick, false)
+		items = make(map[string]*expiration)
+	)
+
+	ttls := []time.Duration{
+		tick / 2,
+		3 * tick,
+		70 * tick,
+		5000 * tick,
+		300000 * tick,
+		20000000 * tick,
+	}
+	for i, ttl := range ttls {
+		e := &expiration{key: fmt.Sprint(i)}
+		w.set(e, deadline(fc.Now(), ttl), true)
+		items[e.key] = e
+	}
+	never := &expiration{key: "never"}
+	w.set(never, deadline(fc.Now(), 0), false)
+	assert.Equal(t, len(ttls), w.Len())
+
+	drain := func() []string {
+		var keys []string
+		for e := w.expired(fc.Now()); e != nil; e = w.expired(fc.Now()) {
+			assert.False(t, e.expireAt.After(fc.Now()))
+			keys = append(keys, e.key)
+			w.remove(e)
+		}
+		return keys
+	}
+
+	assert.Empty(t, drain())
+	for i := range ttls {
+		e := items[fmt.Sprint(i)]
+
+		// step through the small deadlines tick by tick, jump to the far ones.
+		step := tick
+		if i > 3 {
+			step = e.expireAt.Sub(fc.Now()) - time.Millisecond
+		}
+		for fc.Now().Add(step).Before(e.expireAt) {
+			fc.Advance(step)
+			assert.Empty(t, drain())
+		}
+
+		// the wheel reports items at most one tick late.
+		fc.Advance(e.expireAt.Sub(fc.Now()) + tick)
+		assert.Equal(t, []string{e.key}, drain())
+	}
+	assert.Equal(t, 0, w.Len())
+
+	// a rescheduled item moves with its new deadline.
+	e := &expiration{key: "moved"}
+	w.set(e, deadline(fc.Now(), tick), true)
+	w.set(e, deadline(fc.Now(), 100*tick), true)
+	fc.Advance(2 * tick)
+	assert.Empty(t, drain())
+	assert.Equal(t, e, w.peek())
+	fc.Advance(100 * tick)
+	assert.Equal(t, []string{"moved"}, drain())
+
+	// jumps over many slots keep every deadline, however they fall.
+	pending := make(map[*expiration]bool)
+	for i := 1; i <= 200; i++ {
+		e := &expiration{key: fmt.Sprint(i)}
+		w.set(e, deadline(fc.Now(), time.Duration(i*i*37)*tick), true)
+		pending[e] = true
+	}
+	for len(pending) > 0 {
+		fc.Advance(4099 * tick)
+		drain()
+		for e := range pending {
+			if e.slot == nil {
+				delete(pending, e)
+				continue
+			}
+			assert.True(t, e.expireAt.After(fc.Now().Add(-tick)), e.key)
+		}
+	}
+	assert.Equal(t, 0, w.Len())
+}
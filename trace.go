@@ -0,0 +1,161 @@
+package mcache
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Tracer observes the operations of a cache, for instance to record them as
+// spans of a request trace. The calls of the cache (Get, MGet, Set, MSet,
+// Remove and MRemove) are traced, and within them the calls to the loader
+// functions and to Redis, so their spans nest. Without a Tracer nothing is
+// measured.
+type Tracer interface {
+	// StartOp is called before op runs, op runs with the context it returns,
+	// which is also passed to EndOp.
+	StartOp(ctx context.Context, op TraceOp) context.Context
+	// EndOp is called once op completed.
+	EndOp(ctx context.Context, op TraceOp, res TraceResult)
+}
+
+// TraceTier is where a traced operation runs.
+type TraceTier string
+
+const (
+	TierCache  TraceTier = "cache"  //缓存的调用
+	TierDisk   TraceTier = "disk"   //磁盘层
+	TierLoader TraceTier = "loader" //加载函数
+	TierRedis  TraceTier = "redis"  //Redis命令或管道
+)
+
+// Outcome is how a traced operation completed.
+type Outcome string
+
+const (
+	OutcomeHit   Outcome = "hit"   //读到了全部的键, 或删除了键
+	OutcomeMiss  Outcome = "miss"  //有键没有读到或删除
+	OutcomeOK    Outcome = "ok"    //写入或加载成功
+	OutcomeError Outcome = "error" //失败
+)
+
+// TraceOp describes a traced operation.
+type TraceOp struct {
+	// Name is get, mget, set, mset, remove, mremove or invalidate for the
+	// cache calls, load or mload for the loader functions, get for the disk
+	// tier, and get, mget, set, mset, del, mdel, lease or release for Redis,
+	// where mget, mset and mdel are pipelines.
+	Name string
+	Tier TraceTier
+	// Keys is the number of keys of the operation.
+	Keys int
+}
+
+// TraceResult describes a completed operation.
+type TraceResult struct {
+	Outcome  Outcome
+	Duration time.Duration
+	Err      error
+}
+
+// span is a traced operation in progress, it is a no-op without a tracer.
+type span struct {
+	tracer Tracer
+	ctx    context.Context
+	op     TraceOp
+	start  time.Time
+}
+
+func startSpan(ctx context.Context, tracer Tracer, name string, tier TraceTier, keys int) (context.Context, span) {
+	if tracer == nil {
+		return ctx, span{}
+	}
+
+	op := TraceOp{Name: name, Tier: tier, Keys: keys}
+	ctx = tracer.StartOp(ctx, op)
+	return ctx, span{tracer: tracer, ctx: ctx, op: op, start: time.Now()}
+}
+
+func (s span) end(outcome Outcome, err error) {
+	if s.tracer == nil {
+		return
+	}
+	s.tracer.EndOp(s.ctx, s.op, TraceResult{
+		Outcome:  outcome,
+		Duration: time.Since(s.start),
+		Err:      err,
+	})
+}
+
+// writeOutcome is the outcome of a write or a load.
+func writeOutcome(err error) Outcome {
+	if err != nil {
+		return OutcomeError
+	}
+	return OutcomeOK
+}
+
+// readOutcome is the outcome of a read, a missing key is not an error.
+func readOutcome(err error) Outcome {
+	switch {
+	case err == nil:
+		return OutcomeHit
+	case err == redis.Nil, errors.Is(err, KeyNotFoundError), errors.Is(err, DefaultValueSetError):
+		return OutcomeMiss
+	default:
+		return OutcomeError
+	}
+}
+
+// mgetOutcome is the outcome of a read of want keys that found got of them.
+func mgetOutcome(got, want int, err error) Outcome {
+	switch {
+	case err != nil && err != redis.Nil:
+		return OutcomeError
+	case got < want:
+		return OutcomeMiss
+	default:
+		return OutcomeHit
+	}
+}
+
+// removeOutcome is the outcome of a removal.
+func removeOutcome(ok bool, err error) Outcome {
+	switch {
+	case err != nil:
+		return OutcomeError
+	case ok:
+		return OutcomeHit
+	default:
+		return OutcomeMiss
+	}
+}
+
+// ExpvarTracer is a Tracer publishing counters in an expvar.Map: for every
+// tier and operation, the calls by outcome and the nanoseconds spent in them,
+// e.g. "redis.mget.ok" and "redis.mget.nanos".
+type ExpvarTracer struct {
+	m *expvar.Map
+}
+
+// NewExpvarTracer publishes the counters under name, reusing the map already
+// published there if any.
+func NewExpvarTracer(name string) *ExpvarTracer {
+	if m, ok := expvar.Get(name).(*expvar.Map); ok {
+		return &ExpvarTracer{m: m}
+	}
+	return &ExpvarTracer{m: expvar.NewMap(name)}
+}
+
+func (t *ExpvarTracer) StartOp(ctx context.Context, op TraceOp) context.Context {
+	return ctx
+}
+
+func (t *ExpvarTracer) EndOp(ctx context.Context, op TraceOp, res TraceResult) {
+	prefix := string(op.Tier) + "." + op.Name + "."
+	t.m.Add(prefix+string(res.Outcome), 1)
+	t.m.Add(prefix+"nanos", int64(res.Duration))
+}
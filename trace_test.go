@@ -0,0 +1,125 @@
+package mcache_test
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/cgxxv/mcache-go/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingTracer struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (t *recordingTracer) StartOp(ctx context.Context, op mcache.TraceOp) context.Context {
+	t.record(fmt.Sprintf("start %s.%s %d", op.Tier, op.Name, op.Keys))
+	return ctx
+}
+
+func (t *recordingTracer) EndOp(ctx context.Context, op mcache.TraceOp, res mcache.TraceResult) {
+	t.record(fmt.Sprintf("end %s.%s %s", op.Tier, op.Name, res.Outcome))
+}
+
+func (t *recordingTracer) record(event string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.events = append(t.events, event)
+}
+
+func (t *recordingTracer) take() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	events := t.events
+	t.events = nil
+	return events
+}
+
+func TestTracer(t *testing.T) {
+	var (
+		ctx    = context.TODO()
+		tracer = &recordingTracer{}
+		loader = func(ctx context.Context, key string) (interface{}, error) {
+			return key, nil
+		}
+		c = mcache.New[mcache.LruCache](64,
+			mcache.WithRedisClient(redisClient),
+			mcache.WithLoaderFn(loader),
+			mcache.WithTracer(tracer),
+		)
+	)
+	defer c.Close()
+	redisClient.Del(ctx, "trace-a", "trace-b")
+
+	_, err := c.Get(ctx, "trace-a")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"start cache.get 1",
+		"start redis.get 1",
+		"end redis.get miss",
+		"start loader.load 1",
+		"end loader.load ok",
+		"start redis.set 1",
+		"end redis.set ok",
+		"end cache.get hit",
+	}, tracer.take())
+
+	_, err = c.Get(ctx, "trace-a")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"start cache.get 1", "end cache.get hit"}, tracer.take())
+
+	res, err := c.MGet(ctx, []string{"trace-a", "trace-b"})
+	assert.Nil(t, err)
+	assert.Len(t, res, 1)
+	assert.Equal(t, []string{
+		"start cache.mget 2",
+		"start redis.mget 1",
+		"end redis.mget miss",
+		"end cache.mget miss",
+	}, tracer.take())
+
+	assert.True(t, c.Remove(ctx, "trace-a"))
+	assert.False(t, c.Remove(ctx, "trace-a"))
+	assert.Equal(t, []string{
+		"start cache.remove 1",
+		"start redis.del 1",
+		"end redis.del ok",
+		"end cache.remove hit",
+		"start cache.remove 1",
+		"start redis.del 1",
+		"end redis.del ok",
+		"end cache.remove miss",
+	}, tracer.take())
+}
+
+func TestExpvarTracer(t *testing.T) {
+	var (
+		ctx    = context.TODO()
+		tracer = mcache.NewExpvarTracer("mcache_test_trace")
+		c      = mcache.New[mcache.LruCache](64, mcache.WithTracer(tracer))
+	)
+	defer c.Close()
+	assert.NotPanics(t, func() { mcache.NewExpvarTracer("mcache_test_trace") })
+
+	m := expvar.Get("mcache_test_trace").(*expvar.Map)
+	count := func(key string) int64 {
+		if v, ok := m.Get(key).(*expvar.Int); ok {
+			return v.Value()
+		}
+		return 0
+	}
+	hits, misses, sets := count("cache.get.hit"), count("cache.get.miss"), count("cache.set.ok")
+
+	assert.Nil(t, c.Set(ctx, "a", "a"))
+	c.Get(ctx, "a")
+	c.Get(ctx, "b")
+
+	assert.Equal(t, sets+1, count("cache.set.ok"))
+	assert.Equal(t, hits+1, count("cache.get.hit"))
+	assert.Equal(t, misses+1, count("cache.get.miss"))
+	assert.Greater(t, count("cache.get.nanos"), int64(0))
+}
@@ -0,0 +1,347 @@
+package mcache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultWriteBehindBatch    = 1 << 7                 //默认的批量写入键数
+	defaultWriteBehindInterval = 100 * time.Millisecond //默认的刷新间隔
+	defaultWriteBehindPending  = 1 << 14                //默认的最大排队键数
+	defaultWriteBehindRetries  = 3                      //默认的重试次数
+	defaultWriteBehindBackoff  = 100 * time.Millisecond //首次重试前的默认等待
+	maxWriteBehindBackoff      = 10 * time.Second       //重试等待的上限
+)
+
+// WriteBehind configures the asynchronous Redis writes of WithWriteBehind, a
+// zero field takes its default.
+type WriteBehind struct {
+	// BatchSize flushes the queue once that many keys are pending, 128 by
+	// default.
+	BatchSize int
+	// Interval flushes the queue at least that often, 100ms by default.
+	Interval time.Duration
+	// MaxPending bounds the keys queued, 16384 by default. Once reached, the
+	// writes of keys not queued yet only update the local shards and are
+	// counted as dropped.
+	MaxPending int
+	// MaxRetries is how many times a failed write is retried before it is
+	// dropped, 3 by default.
+	MaxRetries int
+	// Backoff is the wait after a failed flush, 100ms by default. It doubles
+	// with every failure in a row, up to 10s.
+	Backoff time.Duration
+}
+
+func (wb *WriteBehind) withDefaults() {
+	if wb.BatchSize <= 0 {
+		wb.BatchSize = defaultWriteBehindBatch
+	}
+	if wb.Interval <= 0 {
+		wb.Interval = defaultWriteBehindInterval
+	}
+	if wb.MaxPending <= 0 {
+		wb.MaxPending = defaultWriteBehindPending
+	}
+	if wb.MaxRetries <= 0 {
+		wb.MaxRetries = defaultWriteBehindRetries
+	}
+	if wb.Backoff <= 0 {
+		wb.Backoff = defaultWriteBehindBackoff
+	}
+}
+
+// WriteBehindStats describes the queue of WithWriteBehind.
+type WriteBehindStats struct {
+	// Pending is the number of keys queued.
+	Pending int
+	// Flushed counts the writes sent to Redis, Retried the writes queued
+	// again after a failed flush and Dropped the writes given up on.
+	Flushed uint64
+	Retried uint64
+	Dropped uint64
+}
+
+type pendingWrite struct {
+	value interface{} // as set, for the reads of the key
+	data  interface{} // as sent to Redis
+	ttl   time.Duration
+	tries int
+}
+
+// writeBehind queues the Redis writes of a cache, coalesced per key, and
+// flushes them in the background.
+type writeBehind struct {
+	cfg      WriteBehind
+	cli      RedisCli
+	clock    Clock
+	staleTTL time.Duration // how long the stale copies of a lease outlive the writes
+
+	mu       sync.Mutex
+	pending  map[string]pendingWrite
+	inflight map[string]pendingWrite // the batch sent to Redis
+	closed   bool
+	flushMu  sync.Mutex // held while a batch is in flight
+
+	kick chan struct{}
+	stop chan struct{}
+	done chan struct{}
+
+	flushed uint64
+	retried uint64
+	dropped uint64
+}
+
+func newWriteBehind(cfg WriteBehind, cli RedisCli, clock Clock, staleTTL time.Duration) *writeBehind {
+	cfg.withDefaults()
+	w := &writeBehind{
+		cfg:      cfg,
+		cli:      cli,
+		clock:    clock,
+		staleTTL: staleTTL,
+		pending:  make(map[string]pendingWrite),
+		kick:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+func (w *writeBehind) run() {
+	defer close(w.done)
+
+	ticker := w.clock.NewTicker(w.cfg.Interval)
+	defer ticker.Stop()
+
+	var backoff time.Duration
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C():
+		case <-w.kick:
+		}
+
+		if err := w.flush(context.Background(), false); err == nil {
+			backoff = 0
+			continue
+		}
+		if backoff = 2 * backoff; backoff == 0 {
+			backoff = w.cfg.Backoff
+		} else if backoff > maxWriteBehindBackoff {
+			backoff = maxWriteBehindBackoff
+		}
+		t := w.clock.NewTimer(backoff)
+		select {
+		case <-w.stop:
+			t.Stop()
+			return
+		case <-t.C():
+		}
+	}
+}
+
+// enqueue queues the writes of keys, encoded with the codec of o, and of
+// their stale copies if the lease keeps any. It returns false without a queue
+// or once it is closed, the writes are then up to the caller.
+func (w *writeBehind) enqueue(ctx context.Context, keys []string, values []interface{}, o options) (bool, error) {
+	if w == nil {
+		return false, nil
+	}
+
+	writes := make([]pendingWrite, len(keys))
+	for i, val := range values {
+		writes[i] = pendingWrite{value: val, data: val, ttl: o.ttlOf(keys[i])}
+		if o.serializeFunc != nil {
+			data, err := o.serializeFunc(ctx, val)
+			if err != nil {
+				return true, err
+			}
+			writes[i].data = data
+		}
+	}
+	if n := len(keys); w.staleTTL > 0 && o.TTL > 0 {
+		keys = keys[:n:n]
+		for i := 0; i < n; i++ {
+			stale := writes[i]
+			stale.ttl = o.TTL + w.staleTTL
+			keys = append(keys, keys[i]+staleKeySuffix)
+			writes = append(writes, stale)
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return false, nil
+	}
+	for i, key := range keys {
+		if _, ok := w.pending[key]; !ok && len(w.pending) >= w.cfg.MaxPending {
+			atomic.AddUint64(&w.dropped, 1)
+			continue
+		}
+		w.pending[key] = writes[i]
+	}
+	if len(w.pending) >= w.cfg.BatchSize {
+		select {
+		case w.kick <- struct{}{}:
+		default:
+		}
+	}
+	return true, nil
+}
+
+// lookup returns the value of a write of key not flushed yet, queued or in
+// flight.
+func (w *writeBehind) lookup(key string) (interface{}, bool) {
+	if w == nil {
+		return nil, false
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	p, ok := w.pending[key]
+	if !ok {
+		p, ok = w.inflight[key]
+	}
+	return p.value, ok
+}
+
+// cancel drops the writes of keys, waiting for a batch in flight so it can't
+// land after the caller deletes them.
+func (w *writeBehind) cancel(keys []string) {
+	if w == nil {
+		return
+	}
+
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, key := range keys {
+		delete(w.pending, key)
+	}
+}
+
+// flush sends the writes queued when it starts in batches of at most
+// BatchSize keys, one pipeline each. The writes of a failed batch are queued
+// again, unless the key was written since, until they run out of retries or
+// final is set. Only a final flush goes on after a failed batch. The keys of
+// the writes dropped while the circuit is open are deleted from Redis once it
+// closes.
+func (w *writeBehind) flush(ctx context.Context, final bool) error {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+
+	w.mu.Lock()
+	left := len(w.pending)
+	w.mu.Unlock()
+
+	var err error
+	for left > 0 {
+		w.mu.Lock()
+		batch := make(map[string]pendingWrite, w.cfg.BatchSize)
+		for key, p := range w.pending {
+			if len(batch) == w.cfg.BatchSize {
+				break
+			}
+			batch[key] = p
+			delete(w.pending, key)
+		}
+		w.inflight = batch
+		w.mu.Unlock()
+		if len(batch) == 0 {
+			break
+		}
+		left -= len(batch)
+
+		if berr := w.flushBatch(ctx, batch, final); berr != nil {
+			err = berr
+			if !final {
+				break
+			}
+		}
+	}
+	return err
+}
+
+func (w *writeBehind) flushBatch(ctx context.Context, batch map[string]pendingWrite, final bool) error {
+	var (
+		keys   = make([]string, 0, len(batch))
+		values = make([]interface{}, 0, len(batch))
+		ttls   = make([]time.Duration, 0, len(batch))
+	)
+	for key, p := range batch {
+		keys = append(keys, key)
+		values = append(values, p.data)
+		ttls = append(ttls, p.ttl)
+	}
+
+	err := w.cli.setBatch(ctx, keys, values, ttls)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.inflight = nil
+	if err == nil {
+		atomic.AddUint64(&w.flushed, uint64(len(batch)))
+		return nil
+	}
+	var lost []string
+	for key, p := range batch {
+		if _, ok := w.pending[key]; ok {
+			continue
+		}
+		if p.tries++; final || p.tries > w.cfg.MaxRetries {
+			atomic.AddUint64(&w.dropped, 1)
+			lost = append(lost, key)
+			continue
+		}
+		w.pending[key] = p
+		atomic.AddUint64(&w.retried, 1)
+	}
+	if errors.Is(err, CircuitOpenError) {
+		// Redis still holds the values these writes replaced.
+		w.cli.breaker.invalidate(lost)
+	}
+	return err
+}
+
+// close stops the background flushes and flushes the queue a last time, the
+// writes failing then are dropped.
+func (w *writeBehind) close(ctx context.Context) error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	w.mu.Unlock()
+
+	close(w.stop)
+	<-w.done
+	return w.flush(ctx, true)
+}
+
+func (w *writeBehind) stats() WriteBehindStats {
+	if w == nil {
+		return WriteBehindStats{}
+	}
+
+	w.mu.Lock()
+	pending := len(w.pending)
+	w.mu.Unlock()
+	return WriteBehindStats{
+		Pending: pending,
+		Flushed: atomic.LoadUint64(&w.flushed),
+		Retried: atomic.LoadUint64(&w.retried),
+		Dropped: atomic.LoadUint64(&w.dropped),
+	}
+}
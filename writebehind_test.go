@@ -0,0 +1,155 @@
+package mcache
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteBehind(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.Nil(t, err)
+	defer mr.Close()
+
+	var (
+		ctx      = context.TODO()
+		fc       = NewFakeClock()
+		client   = WithRedisClient(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+		codec    = WithUnSafeValBind(func() interface{} { return new(string) })
+		interval = time.Second
+		cfg      = WriteBehind{BatchSize: 4, Interval: interval, MaxPending: 6, MaxRetries: 1}
+		c        = New[LruCache](64, client, codec, WithClock(fc), WithWriteBehind(cfg))
+		remote   = New[LruCache](64, client, codec)
+	)
+	defer remote.Close()
+	// tick advances the clock for the ticker or the backoff timer of the
+	// queue, whichever it waits on.
+	tick := func(cond func() bool) {
+		assert.Eventually(t, func() bool {
+			fc.Advance(interval)
+			return cond()
+		}, time.Second, time.Millisecond)
+	}
+
+	// writes are coalesced per key and readable before they are flushed.
+	assert.Nil(t, c.Set(ctx, "a", "1"))
+	assert.Nil(t, c.Set(ctx, "a", "2"))
+	assert.Nil(t, c.Set(ctx, "b", "3", WithTTL(time.Minute)))
+	assert.False(t, mr.Exists("a"))
+	assert.Equal(t, 2, c.Stats().WriteBehind.Pending)
+	c.debugLocalRemove(ctx, "a")
+	val, err := c.Get(ctx, "a")
+	assert.Nil(t, err)
+	assert.Equal(t, "2", val)
+
+	tick(func() bool { return mr.Exists("a") })
+	val, err = remote.Get(ctx, "a")
+	assert.Nil(t, err)
+	assert.Equal(t, "2", val)
+	assert.True(t, mr.TTL("b") > 0)
+	assert.Equal(t, uint64(2), c.Stats().WriteBehind.Flushed)
+
+	// a full batch is flushed without waiting for the interval.
+	assert.Nil(t, c.MSet(ctx, []string{"c", "d", "e", "f"}, []interface{}{"4", "5", "6", "7"}))
+	assert.Eventually(t, func() bool { return mr.Exists("f") }, time.Second, time.Millisecond)
+
+	// a removed key is never flushed.
+	assert.Nil(t, c.Set(ctx, "g", "8"))
+	assert.True(t, c.Remove(ctx, "g"))
+	assert.Equal(t, 0, c.Stats().WriteBehind.Pending)
+
+	// failed writes are retried, then dropped, and a full queue drops the
+	// writes of new keys.
+	mr.Close()
+	for i := 0; i < 7; i++ {
+		assert.Nil(t, c.Set(ctx, fmt.Sprint("k", i), "v"))
+	}
+	assert.Equal(t, uint64(1), c.Stats().WriteBehind.Dropped)
+	tick(func() bool { return c.Stats().WriteBehind.Dropped == 7 })
+	assert.Equal(t, uint64(6), c.Stats().WriteBehind.Retried)
+	assert.Nil(t, mr.Restart())
+
+	// Close flushes the queue, the later writes are synchronous.
+	assert.Nil(t, c.Set(ctx, "h", "9"))
+	assert.Nil(t, c.Close())
+	assert.True(t, mr.Exists("h"))
+	assert.Nil(t, c.Set(ctx, "i", "10"))
+	assert.True(t, mr.Exists("i"))
+	assert.Nil(t, c.Close())
+}
+
+// blockingHook holds the pipelines sent to Redis until release is closed,
+// after reporting their keys.
+type blockingHook struct {
+	batches chan []string
+	release chan struct{}
+}
+
+func (h blockingHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (h blockingHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	return nil
+}
+
+func (h blockingHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	keys := make([]string, len(cmds))
+	for i, cmd := range cmds {
+		keys[i] = fmt.Sprint(cmd.Args()[1])
+	}
+	h.batches <- keys
+	<-h.release
+	return ctx, nil
+}
+
+func (h blockingHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	return nil
+}
+
+func TestWriteBehindInFlight(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.Nil(t, err)
+	defer mr.Close()
+
+	var (
+		ctx    = context.TODO()
+		fc     = NewFakeClock()
+		rc     = redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		hook   = blockingHook{batches: make(chan []string, 16), release: make(chan struct{})}
+		codec  = WithUnSafeValBind(func() interface{} { return new(string) })
+		cfg    = WriteBehind{BatchSize: 2, Interval: time.Second}
+		c      = New[LruCache](64, WithRedisClient(rc), codec, WithClock(fc), WithWriteBehind(cfg))
+		remote = New[LruCache](64, WithRedisClient(redis.NewClient(&redis.Options{Addr: mr.Addr()})), codec)
+	)
+	defer remote.Close()
+
+	// the first batch is held in flight, its keys are still read from the
+	// queue rather than from Redis.
+	assert.Nil(t, c.MSet(ctx, []string{"a", "b", "c", "d", "e"}, []interface{}{"old", "old", "old", "old", "old"}, WithWriteMode(WriteAround)))
+	rc.AddHook(hook)
+	assert.Nil(t, c.MSet(ctx, []string{"a", "b", "c", "d", "e"}, []interface{}{"1", "2", "3", "4", "5"}))
+	batch := <-hook.batches
+	assert.Len(t, batch, 2)
+	for _, key := range batch {
+		c.debugLocalRemove(ctx, key)
+		val, err := c.Get(ctx, key)
+		assert.Nil(t, err)
+		assert.NotEqual(t, "old", val)
+	}
+
+	// the queue is flushed in batches of at most BatchSize keys.
+	close(hook.release)
+	assert.Nil(t, c.Close())
+	assert.Len(t, <-hook.batches, 2)
+	assert.Len(t, <-hook.batches, 1)
+	val, err := remote.Get(ctx, "a")
+	assert.Nil(t, err)
+	assert.Equal(t, "1", val)
+	assert.Equal(t, uint64(5), c.Stats().WriteBehind.Flushed)
+}
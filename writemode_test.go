@@ -0,0 +1,67 @@
+package mcache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteModes(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.Nil(t, err)
+	defer mr.Close()
+
+	var (
+		ctx    = context.TODO()
+		client = WithRedisClient(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+		codec  = WithUnSafeValBind(func() interface{} { return new(string) })
+		c      = New[LruCache](64, client, codec)
+	)
+	defer c.Close()
+
+	assert.Nil(t, c.Set(ctx, "through", "1"))
+	assert.True(t, mr.Exists("through"))
+	assert.True(t, c.Exists(ctx, "through"))
+
+	assert.Nil(t, c.Set(ctx, "local", "2", WithWriteMode(WriteLocal)))
+	assert.False(t, mr.Exists("local"))
+	assert.True(t, c.Exists(ctx, "local"))
+
+	// write-around drops the local copy, the next Get reads Redis.
+	assert.Nil(t, c.Set(ctx, "through", "3", WithWriteMode(WriteAround)))
+	assert.False(t, c.Exists(ctx, "through"))
+	val, err := c.Get(ctx, "through")
+	assert.Nil(t, err)
+	assert.Equal(t, "3", val)
+
+	// a key only in Redis is removed, and removing keeps going past a
+	// missing key.
+	assert.True(t, c.debugLocalRemove(ctx, "through"))
+	assert.True(t, c.Remove(ctx, "through"))
+	assert.Nil(t, c.MSet(ctx, []string{"a", "b"}, []interface{}{"4", "5"}))
+	assert.False(t, c.MRemove(ctx, []string{"missing", "a", "b"}))
+	assert.False(t, c.Exists(ctx, "b"))
+	assert.False(t, mr.Exists("b"))
+
+	// Redis down: only the best-effort writes succeed, removals still drop
+	// the local copies.
+	mr.Close()
+	assert.NotNil(t, c.Set(ctx, "down", "6"))
+	assert.False(t, c.Exists(ctx, "down"))
+	assert.NotNil(t, c.Set(ctx, "down", "6", WithWriteMode(WriteAround)))
+	assert.Nil(t, c.Set(ctx, "down", "6", WithWriteMode(WriteBestEffort)))
+	assert.True(t, c.Exists(ctx, "down"))
+	assert.False(t, c.Remove(ctx, "down"))
+	assert.False(t, c.Exists(ctx, "down"))
+	assert.Nil(t, c.Set(ctx, "down", "6", WithWriteMode(WriteLocal)))
+	assert.True(t, c.Remove(ctx, "down", WithWriteMode(WriteBestEffort)))
+
+	// the mode of the cache is the default of its calls.
+	l := New[LruCache](64, client, WithWriteMode(WriteLocal))
+	defer l.Close()
+	assert.Nil(t, l.Set(ctx, "down", "7"))
+	assert.True(t, l.Remove(ctx, "down"))
+}
@@ -0,0 +1,55 @@
+package mcache
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// ttlOf returns the TTL of key, shortened by up to TTLJitter of it. The share
+// comes from the hash of key, seeded per process, so every write of key in a
+// process gets the same TTL.
+func (o options) ttlOf(key string) time.Duration {
+	if o.TTL <= 0 || o.TTLJitter <= 0 {
+		return o.TTL
+	}
+	jitter := math.Min(o.TTLJitter, 1)
+	share := float64(MemHashString(key)>>11) / (1 << 53)
+	ttl := o.TTL - time.Duration(jitter*share*float64(o.TTL))
+	if ttl < time.Millisecond {
+		ttl = time.Millisecond // the precision of Redis, 0 would never expire
+	}
+	return ttl
+}
+
+// expireEarly reports whether the live local entry of key is to be reloaded
+// ahead of its deadline, following XFetch with the time its value took to
+// load. Entries whose load time wasn't recorded are never reloaded early.
+func (c *cacheHandler[T, P]) expireEarly(ctx context.Context, key string, o options) bool {
+	if o.XFetch <= 0 || o.reloadFunc == nil {
+		return false
+	}
+	deadline, delta, ok := c.localDeadline(ctx, key)
+	if !ok || deadline.IsZero() || delta <= 0 {
+		return false
+	}
+	gap := -o.XFetch * float64(delta) * math.Log(1-rand.Float64())
+	return gap >= float64(deadline.Sub(c.clock.Now()))
+}
+
+// reload loads key again while its value val is still live. The value loaded
+// is kept, val is returned if the load fails.
+func (c *cacheHandler[T, P]) reload(ctx context.Context, key string, val interface{}, o options) interface{} {
+	atomic.AddUint64(&c.stats.earlyReloads, 1)
+	v, took, err := o.reloadFunc(ctx, key)
+	if err != nil {
+		return val
+	}
+	if err := c.localSet(ctx, key, v, o.ttlOf(key)); err != nil {
+		return val
+	}
+	c.localSetLoadTime(ctx, key, took)
+	return v
+}
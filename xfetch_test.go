@@ -0,0 +1,124 @@
+package mcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestXFetch(t *testing.T) {
+	var (
+		ctx   = context.TODO()
+		fc    = NewFakeClock()
+		loads int
+		fail  bool
+	)
+	c := New[LruCache](64, WithClock(fc), WithTTL(time.Minute), WithLoaderFn(func(ctx context.Context, key string) (interface{}, error) {
+		if key == "a" {
+			fc.Advance(time.Millisecond)
+		}
+		if fail {
+			return nil, errors.New("failure")
+		}
+		loads++
+		return loads, nil
+	}))
+	defer c.Close()
+	h := c.(*cacheHandler[LruCache, *LruCache])
+
+	// the entry keeps how long its value took to load.
+	val, err := c.Get(ctx, "a")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, val)
+	_, loadTime, ok := h.localDeadline(ctx, "a")
+	assert.True(t, ok)
+	assert.Equal(t, time.Millisecond, loadTime)
+
+	// far from its deadline an entry is not reloaded.
+	val, err = c.Get(ctx, "a", WithXFetch(1))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, val)
+
+	// close to it, with a large beta, it is.
+	fc.Advance(time.Minute - time.Millisecond)
+	val, err = c.Get(ctx, "a")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, val)
+	val, err = c.Get(ctx, "a", WithXFetch(1e9))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, val)
+	assert.Equal(t, uint64(1), c.Stats().EarlyReloads)
+
+	// the reloaded entry lives a full TTL again.
+	fc.Advance(time.Second)
+	val, err = c.Get(ctx, "a")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, val)
+
+	// a failed reload returns the live value.
+	fail = true
+	fc.Advance(time.Minute - 2*time.Second)
+	val, err = c.Get(ctx, "a", WithXFetch(1e9))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, val)
+	assert.Equal(t, uint64(2), c.Stats().EarlyReloads)
+
+	// an entry loaded in no time is not reloaded early, whatever the other
+	// loads took.
+	fail = false
+	val, err = c.Get(ctx, "b")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, val)
+	fc.Advance(time.Minute - time.Millisecond)
+	val, err = c.Get(ctx, "b", WithXFetch(1e9))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, val)
+	assert.Equal(t, uint64(2), c.Stats().EarlyReloads)
+}
+
+func TestTTLJitter(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.Nil(t, err)
+	defer mr.Close()
+
+	var (
+		ctx    = context.TODO()
+		fc     = NewFakeClock()
+		client = redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+		codec  = WithUnSafeValBind(func() interface{} { return new(string) })
+		c      = New[LruCache](64, WithRedisClient(client), codec, WithClock(fc), WithTTL(time.Minute))
+		h      = c.(*cacheHandler[LruCache, *LruCache])
+		o      = options{TTL: time.Minute, TTLJitter: 0.5}
+	)
+	defer c.Close()
+
+	keys := make([]string, 16)
+	values := make([]interface{}, len(keys))
+	for i := range keys {
+		keys[i], values[i] = fmt.Sprintf("k%d", i), "v"
+	}
+	assert.Nil(t, c.MSet(ctx, keys, values, WithTTLJitter(0.5)))
+	assert.Nil(t, c.Set(ctx, "plain", "v"))
+
+	ttls := make(map[time.Duration]bool)
+	for _, key := range keys {
+		ttl := o.ttlOf(key)
+		assert.Equal(t, ttl, o.ttlOf(key))
+		assert.True(t, ttl > 30*time.Second && ttl <= time.Minute)
+		ttls[ttl] = true
+
+		// the local and Redis copies expire together.
+		deadline, _, ok := h.localDeadline(ctx, key)
+		assert.True(t, ok)
+		assert.Equal(t, fc.Now().Add(ttl), deadline)
+		assert.Equal(t, ttl.Truncate(time.Second), mr.TTL(key))
+	}
+	assert.True(t, len(ttls) > 1)
+	assert.Equal(t, time.Minute, mr.TTL("plain"))
+}